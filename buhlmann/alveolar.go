@@ -0,0 +1,54 @@
+package buhlmann
+
+import "fmt"
+
+const (
+	// Alveolar partial pressure of Carbon Dioxide in bar, equivalent to 40
+	// mmHg.
+	DefaultPACO2 = 0.0533
+	// Respiratory quotients (RQ) used in published decompression models. An RQ
+	// of 1.0 means that the alveolar CO2 has no effect on the inert gas
+	// pressures.
+	SchreinerRQ = 0.8
+	USNavyRQ    = 0.9
+	BuhlmannRQ  = 1.0
+)
+
+// SetAlveolarGas() configures the alveolar gas equation used to calculate the
+// inspired inert gas pressures from the alveolar partial pressure of Carbon
+// Dioxide (PACO2) in bar and the respiratory quotient (RQ), as given by
+// Schreiner:
+//
+//	palv = (pamb - pH2O + paco2 * (1 - rq) / rq) * fig
+//
+// By default, a model uses a PACO2 of zero and an RQ of 1.0 which only
+// accounts for water vapour. For example, SetAlveolarGas(DefaultPACO2,
+// SchreinerRQ) matches implementations that follow Schreiner's papers. The
+// compartments are saturated again at the current ambient pressure, so it
+// should only be called before the dive is started.
+func (m *ZhlModel) SetAlveolarGas(paco2, rq float64) error {
+	if paco2 < 0.0 || paco2 > 0.1 {
+		return fmt.Errorf("buhlmann: Invalid PACO2 value (%f), should be between 0.0 and 0.1", paco2)
+	}
+
+	if rq < 0.7 || rq > 1.0 {
+		return fmt.Errorf("buhlmann: Invalid respiratory quotient (%f), should be between 0.7 and 1.0", rq)
+	}
+
+	m.paco2 = paco2
+	m.rq = rq
+	m.saturate(m.currP)
+	return nil
+}
+
+// AlveolarGas() returns the model's PACO2 in bar and respiratory quotient.
+func (m *ZhlModel) AlveolarGas() (float64, float64) {
+	return m.paco2, m.rq
+}
+
+// alveolarPressure() returns the ambient pressure in bar adjusted for the
+// alveolar CO2 so that the Schreiner equation gives the alveolar inert gas
+// pressures of the model's alveolar gas equation.
+func (m *ZhlModel) alveolarPressure(pamb float64) float64 {
+	return pamb + m.paco2*(1.0-m.rq)/m.rq
+}
@@ -0,0 +1,77 @@
+package buhlmann
+
+import (
+	"math"
+	"testing"
+
+	"github.com/m5lapp/diveplanner/gasmix"
+	"github.com/m5lapp/diveplanner/helpers"
+)
+
+func TestSetAlveolarGas(t *testing.T) {
+	tests := []struct {
+		name    string
+		paco2   float64
+		rq      float64
+		wantErr bool
+	}{
+		{"Schreiner", DefaultPACO2, SchreinerRQ, false},
+		{"US Navy", DefaultPACO2, USNavyRQ, false},
+		{"Bühlmann", DefaultPACO2, BuhlmannRQ, false},
+		{"Negative PACO2", -0.01, SchreinerRQ, true},
+		{"PACO2 too high", 0.2, SchreinerRQ, true},
+		{"RQ too low", DefaultPACO2, 0.5, true},
+		{"RQ too high", DefaultPACO2, 1.1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(gasmix.NewAirMix(), ZHL16C)
+			err := m.SetAlveolarGas(tt.paco2, tt.rq)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("want error: %v; got: %v", tt.wantErr, err)
+			}
+
+			paco2, rq := m.AlveolarGas()
+			if tt.wantErr && (paco2 != 0.0 || rq != BuhlmannRQ) {
+				t.Errorf("want: 0.0, %f; got: %f, %f", BuhlmannRQ, paco2, rq)
+			} else if !tt.wantErr && (paco2 != tt.paco2 || rq != tt.rq) {
+				t.Errorf("want: %f, %f; got: %f, %f", tt.paco2, tt.rq, paco2, rq)
+			}
+
+			// The compartments should be saturated with the alveolar gas.
+			want := 0.79 * (atmPressure - pH2O + paco2*(1.0-rq)/rq)
+			if !helpers.EqualFloat64(m.compartments[0].pN2, want) {
+				t.Errorf("saturated pN2 want: %f; got: %f", want, m.compartments[0].pN2)
+			}
+		})
+	}
+}
+
+func TestAlveolarGasLoading(t *testing.T) {
+	air := gasmix.NewAirMix()
+	m := New(air, ZHL16C)
+	m.SetAlveolarGas(DefaultPACO2, SchreinerRQ)
+	m.TransitionCalc(30.0, 30.0)
+	start := m.compartments[4].pN2
+	m.StopCalc(20.0)
+
+	// At a constant depth, the Schreiner equation reduces to the Haldane
+	// equation with the alveolar inert gas pressure.
+	palv := (4.0 - pH2O + DefaultPACO2*(1.0-SchreinerRQ)/SchreinerRQ) * air.FN2
+	k := math.Log(2.0) / m.coefs[4].n2Ht
+	want := palv + (start-palv)*math.Exp(-k*20.0)
+	if !helpers.EqualFloat64(m.compartments[4].pN2, want) {
+		t.Errorf("pN2 want: %f; got: %f", want, m.compartments[4].pN2)
+	}
+
+	// A respiratory quotient below 1.0 raises the alveolar inert gas pressure
+	// which should shorten the NDL.
+	schreiner, def := New(air, ZHL16C), New(air, ZHL16C)
+	schreiner.SetAlveolarGas(DefaultPACO2, SchreinerRQ)
+	schreiner.TransitionCalc(30.0, 30.0)
+	def.TransitionCalc(30.0, 30.0)
+	if schreiner.NDL(0).Duration >= def.NDL(0).Duration {
+		t.Errorf("NDL want less than %v; got: %v", def.NDL(0), schreiner.NDL(0))
+	}
+}
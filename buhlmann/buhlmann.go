@@ -10,16 +10,18 @@ package buhlmann
 //   https://www.medmastery.com/guide/blood-gas-analysis-clinical-guide/partial-pressure-and-alveolar-air-equation-made-simple
 
 import (
+	"fmt"
 	"math"
+	"time"
 
+	"github.com/m5lapp/diveplanner/deco"
 	"github.com/m5lapp/diveplanner/gasmix"
 	"github.com/m5lapp/diveplanner/helpers"
 )
 
 const (
-	// Atmospheric pressure in bar at sea-level.
-	// TODO: Make this a function that accounts for altitude.
-	// ISSUE: 1.01325 bar is a more accurate figure.
+	// Atmospheric pressure in bar at sea-level in the default environment. Use
+	// NewWithEnvironment() to model dives at altitude.
 	atmPressure = 1.0
 	// Number of compartments in each ZH-L model.
 	compartCount = 16
@@ -39,15 +41,15 @@ type compartCoefs struct {
 }
 
 // Custom type to represent a set of compartment coefficients.
-type compartCoefSet int
+type CoefSet int
 
 const (
-	ZHL16A compartCoefSet = iota
+	ZHL16A CoefSet = iota
 	ZHL16B
 	ZHL16C
 )
 
-func (ccs compartCoefSet) String() string {
+func (ccs CoefSet) String() string {
 	return [...]string{"ZH-L16A", "ZH-L16B", "ZH-L16C"}[ccs]
 }
 
@@ -106,43 +108,144 @@ var compartCoefSets = [][compartCount]compartCoefs{
 	},
 }
 
+// Custom type to represent how the a and b coefficients are chosen for each
+// compartment when it contains a mix of Helium and Nitrogen.
+type InertGasCoefs int
+
+const (
+	// Weight the Helium and Nitrogen a and b values by the pressure of each
+	// inert gas in the compartment. This is the standard ZH-L16 approach.
+	WeightedCoefs InertGasCoefs = iota
+	// Use the Nitrogen a and b values for any mix other than Heliox. For
+	// Trimix, this is more conservative than weighting the a and b values.
+	NitrogenCoefs
+)
+
+func (igc InertGasCoefs) String() string {
+	return [...]string{"Weighted", "Nitrogen"}[igc]
+}
+
 // Represents the pressure of Helium and Nitrogen in a tissue compartment.
 type compartModel struct {
 	pHe float64 // Pressure of Helium.
 	pN2 float64 // Pressure of Nitrogen.
 }
 
+// Check that ZhlModel implements the deco.Model interface.
+var _ deco.Model = (*ZhlModel)(nil)
+
 type ZhlModel struct {
-	ccs          compartCoefSet
-	coefs        *[compartCount]compartCoefs
+	ccs   CoefSet
+	coefs *[compartCount]compartCoefs
+	// Custom coefficients used instead of the coefficient set, if any.
+	coefTable    *CoefTable
 	compartments *[compartCount]compartModel
 	currP        float64
 	currT        float64
 	gasMix       *gasmix.GasMix
+	// Gradient factors as fractions of the M-value. gfLow controls the depth
+	// of the first decompression stop and gfHigh the allowable tissue loading
+	// on surfacing.
+	gfLow  float64
+	gfHigh float64
+	// Gases available for use during decompression stops.
+	decoGases deco.Gases
+	// The environment used to convert between depth and pressure.
+	env helpers.Environment
+	// How the a and b values are chosen for mixes containing Helium.
+	inertGasCoefs InertGasCoefs
+	// Options used to calculate decompression stops.
+	decoOpts DecoOptions
+	// Alveolar partial pressure of CO2 in bar and the respiratory quotient
+	// used in the alveolar gas equation, see SetAlveolarGas().
+	paco2 float64
+	rq    float64
 }
 
 // Constructor that creates, initialises and returns a new Bühlmann ZHL-16
 // model. The initial value of pN takes into account the Partial Pressure of
 // water vapour in the lungs which offsets some of the volume of Nitrogen in the
 // air.
-func New(gm *gasmix.GasMix, ccs compartCoefSet) *ZhlModel {
-	// Create the compartment model and initialise the values for each one.
-	var c [compartCount]compartModel
-	for i := 0; i < compartCount; i++ {
-		c[i] = compartModel{
-			pHe: 0.0,
-			pN2: 0.79 * (1.0 - pH2O),
-		}
-	}
+func New(gm *gasmix.GasMix, ccs CoefSet) *ZhlModel {
+	return NewWithEnvironment(gm, ccs, helpers.DefaultEnvironment())
+}
 
-	return &ZhlModel{
+// NewWithEnvironment() is like New() but for a dive in the given environment.
+// The diver's tissues are assumed to be fully saturated with air at the
+// environment's surface pressure, see Acclimatise() to model a diver who has
+// recently arrived at altitude.
+func NewWithEnvironment(gm *gasmix.GasMix, ccs CoefSet, env helpers.Environment) *ZhlModel {
+	surfaceP := env.Pressure(0.0)
+
+	m := &ZhlModel{
 		ccs:          ccs,
 		coefs:        &compartCoefSets[ccs],
-		compartments: &c,
-		currP:        atmPressure,
+		compartments: &[compartCount]compartModel{},
+		currP:        surfaceP,
 		currT:        0.0,
 		gasMix:       gm,
+		gfLow:        1.0,
+		gfHigh:       1.0,
+		env:          env,
+		rq:           BuhlmannRQ,
 	}
+	m.saturate(surfaceP)
+
+	return m
+}
+
+// saturate() initialises each of the model's compartments so that they are
+// fully saturated from breathing air at the given ambient pressure in bar.
+func (m *ZhlModel) saturate(pamb float64) {
+	for i := 0; i < compartCount; i++ {
+		m.compartments[i] = compartModel{
+			pHe: 0.0,
+			pN2: deco.SaturatedN2(m.alveolarPressure(pamb), pH2O),
+		}
+	}
+}
+
+// Acclimatise() models a diver who was fully saturated with air at the given
+// pressure in bar, for instance at sea-level, arriving at the dive site and
+// then spending the given time in minutes breathing air at the surface before
+// the dive. It should only be called before the dive is started as it resets
+// the compartment loading.
+func (m *ZhlModel) Acclimatise(fromP, time float64) {
+	m.saturate(fromP)
+	m.currP = m.env.Pressure(0.0)
+	deco.SurfaceInterval(m, time)
+
+	// The time spent acclimatising is not part of the dive itself.
+	m.currT = 0.0
+}
+
+// SetGradientFactors() configures the model's gradient factors, expressed as
+// fractions of the M-value, e.g. 0.3 and 0.85 for GF 30/85. By default a new
+// model uses 1.0 for both which is equivalent to the unmodified ZH-L16 M-values.
+// gfLow must be greater than zero and must not exceed gfHigh which must not
+// exceed 1.0. A low gfHigh along with a last stop at 6m can mean that the last
+// stop never clears, in which case DecoStops() returns an error.
+func (m *ZhlModel) SetGradientFactors(gfLow, gfHigh float64) error {
+	if gfLow <= 0.0 || gfLow > 1.0 {
+		return fmt.Errorf("buhlmann: Invalid GF low value (%f), should be greater than 0.0 and no more than 1.0", gfLow)
+	}
+
+	if gfHigh <= 0.0 || gfHigh > 1.0 {
+		return fmt.Errorf("buhlmann: Invalid GF high value (%f), should be greater than 0.0 and no more than 1.0", gfHigh)
+	}
+
+	if gfLow > gfHigh {
+		return fmt.Errorf("buhlmann: Invalid GF low (%f) and GF high (%f) values, GF low should not exceed GF high", gfLow, gfHigh)
+	}
+
+	m.gfLow = gfLow
+	m.gfHigh = gfHigh
+	return nil
+}
+
+// GradientFactors() returns the model's GF low and GF high values.
+func (m *ZhlModel) GradientFactors() (float64, float64) {
+	return m.gfLow, m.gfHigh
 }
 
 // copyModel() returns a deep copy of the Bühlmann model that can be used for
@@ -159,43 +262,104 @@ func (m *ZhlModel) copyModel() *ZhlModel {
 	}
 
 	return &ZhlModel{
-		ccs:          m.ccs,
-		coefs:        m.coefs,
-		compartments: &compartCopy,
-		currP:        m.currP,
-		currT:        m.currT,
-		gasMix:       m.gasMix,
+		ccs:           m.ccs,
+		coefs:         m.coefs,
+		coefTable:     m.coefTable,
+		compartments:  &compartCopy,
+		currP:         m.currP,
+		currT:         m.currT,
+		gasMix:        m.gasMix,
+		gfLow:         m.gfLow,
+		gfHigh:        m.gfHigh,
+		decoGases:     append(deco.Gases(nil), m.decoGases...),
+		env:           m.env,
+		inertGasCoefs: m.inertGasCoefs,
+		decoOpts:      m.decoOpts,
+		paco2:         m.paco2,
+		rq:            m.rq,
+	}
+}
+
+// SetInertGasCoefs() sets how the a and b values for each compartment are chosen
+// when calculating the ascent ceiling for mixes containing Helium. The default
+// is WeightedCoefs.
+func (m *ZhlModel) SetInertGasCoefs(igc InertGasCoefs) {
+	m.inertGasCoefs = igc
+}
+
+// InertGasCoefs() returns how the model chooses the a and b values for mixes
+// containing Helium.
+func (m *ZhlModel) InertGasCoefs() InertGasCoefs {
+	return m.inertGasCoefs
+}
+
+// Copy() returns a deep copy of the model which can be used to extrapolate
+// from the current state without modifying the original.
+func (m *ZhlModel) Copy() *ZhlModel {
+	return m.copyModel()
+}
+
+// Clone() is like Copy() but returns the copy as a deco.Model.
+func (m *ZhlModel) Clone() deco.Model {
+	return m.copyModel()
+}
+
+// GasMix() returns the breathing gas mix that the model is currently using.
+func (m *ZhlModel) GasMix() *gasmix.GasMix {
+	return m.gasMix
+}
+
+// SwitchGas() changes the breathing gas mix used by the model for any
+// subsequent transitions and stops.
+func (m *ZhlModel) SwitchGas(gm *gasmix.GasMix) {
+	m.gasMix = gm
+}
+
+// AddDecoGas() registers a gas mix that can be used during decompression. The
+// switch depth is the gas mix's MOD at the given maximum PPO2, typically 1.6
+// bar for decompression gases. When calculating decompression stops, the model
+// will switch to the gas with the highest fraction of Oxygen that is available
+// at each stop depth.
+func (m *ZhlModel) AddDecoGas(gm *gasmix.GasMix, maxPPO2 float64) error {
+	return m.decoGases.Add(gm, maxPPO2, m.env)
+}
+
+// ClearDecoGases() removes all of the decompression gases registered with the
+// model.
+func (m *ZhlModel) ClearDecoGases() {
+	m.decoGases = nil
+}
+
+// TissuePressures() returns the total inert gas pressure in bar (Nitrogen plus
+// Helium) in each of the model's compartments.
+func (m *ZhlModel) TissuePressures() []float64 {
+	pressures := make([]float64, compartCount)
+	for i, c := range m.compartments {
+		pressures[i] = c.pHe + c.pN2
 	}
+	return pressures
 }
 
 // The Schreiner Equation calculates the gas loading for a descent or ascent.
-// pamb is the ambient pressure at the start of the calculation.
+// pamb is the ambient pressure at the start of the calculation, which may be
+// adjusted for alveolar CO2, see alveolarPressure().
 // t is the time that the transition will take in minutes.
 // prate is the pressure change in bar per minute.
 // fig is the fraction of inert gas (Nitrogen or Helium).
 // pi is the initial pressure of the inert gas in the compartment.
 // ht is the inert gas half-time for the curent compartment.
+// See deco.SchreinerEquation(), which this calls with the model's pH2O.
 func schreinerEquation(pamb, t, prate, fig, pi, ht float64) float64 {
-	// palv is the partial pressure of the inert gas being inspired inside the
-	// lungs (alveoli). The calculation accounts for the water vapour content
-	// constant which reduces the partial pressure of the inert gas from what it
-	// would otherwise be at the given ambient pressure.
-	palv := (pamb - pH2O) * fig
-	// k is the inert gas' half-time constant.
-	k := math.Log(2.0) / ht
-	// r is the rate of change in the inspired inert gas' pressure in bar/min.
-	r := prate * fig
-
-	return palv + r*(t-(1.0/k)) - (palv-pi-(r/k))*math.Pow(math.E, (-k*t))
+	return deco.SchreinerEquation(pamb, pH2O, t, prate, fig, pi, ht)
 }
 
 // TransitionCalc() recalculates the model's compartment inert gas pressures
 // following a descent or ascent to the given depth at the given rate in m/min.
 func (m *ZhlModel) TransitionCalc(depth, rate float64) {
 	// Ambient pressure at the end of the transition.
-	nextP := helpers.Pressure(depth)
+	nextP := m.env.Pressure(depth)
 	// Pressure change in bar per minute at the given rate of metres per minute.
-	pRate := rate / 10.0
+	pRate := m.env.PressureChangePerMin(rate)
 	if nextP < m.currP && rate >= 0.0 {
 		// We are ascending, so pressure change rate should be negative.
 		pRate *= -1.0
@@ -207,8 +371,8 @@ func (m *ZhlModel) TransitionCalc(depth, rate float64) {
 	// compartment.
 	// TODO: Can these be parallelised?
 	for i, c := range m.compartments {
-		m.compartments[i].pHe = schreinerEquation(m.currP, time, pRate, m.gasMix.FHe, c.pHe, m.coefs[i].heHt)
-		m.compartments[i].pN2 = schreinerEquation(m.currP, time, pRate, m.gasMix.FN2, c.pN2, m.coefs[i].n2Ht)
+		m.compartments[i].pHe = schreinerEquation(m.alveolarPressure(m.currP), time, pRate, m.gasMix.FHe, c.pHe, m.coefs[i].heHt)
+		m.compartments[i].pN2 = schreinerEquation(m.alveolarPressure(m.currP), time, pRate, m.gasMix.FN2, c.pN2, m.coefs[i].n2Ht)
 	}
 
 	// Update the time and ambient pressure at the end of the transition.
@@ -224,8 +388,8 @@ func (m *ZhlModel) StopCalc(time float64) {
 	// compartment. Note that prate is set to zero as we are staying at one
 	// level.
 	for i, c := range m.compartments {
-		m.compartments[i].pHe = schreinerEquation(m.currP, time, 0.0, m.gasMix.FHe, c.pHe, m.coefs[i].heHt)
-		m.compartments[i].pN2 = schreinerEquation(m.currP, time, 0.0, m.gasMix.FN2, c.pN2, m.coefs[i].n2Ht)
+		m.compartments[i].pHe = schreinerEquation(m.alveolarPressure(m.currP), time, 0.0, m.gasMix.FHe, c.pHe, m.coefs[i].heHt)
+		m.compartments[i].pN2 = schreinerEquation(m.alveolarPressure(m.currP), time, 0.0, m.gasMix.FN2, c.pN2, m.coefs[i].n2Ht)
 	}
 
 	// Update the time at the end of the transition. The ambient pressure
@@ -234,43 +398,228 @@ func (m *ZhlModel) StopCalc(time float64) {
 }
 
 // ascentCeiling() calculates the minimum (shallowest) depth in metres to which
-// the diver can ascend safely based on their current compartment loading. If
-// the ascent ceiling is greater than zero metres, then the dive is a
-// decompression dive. The return value is an absolute pressure in bar.
+// the diver can ascend safely based on their current compartment loading and
+// the model's GF high value. If the ascent ceiling is greater than zero metres,
+// then the dive is a decompression dive.
 func (m *ZhlModel) ascentCeiling() float64 {
+	return m.ascentCeilingGF(m.gfHigh)
+}
+
+// Ceiling() returns the ascent ceiling in metres based on the model's current
+// compartment loading and GF high value.
+func (m *ZhlModel) Ceiling() float64 {
+	return m.ascentCeiling()
+}
+
+// ascentCeilingGF() calculates the ascent ceiling depth in metres for the given
+// gradient factor. The tolerated ambient pressure for each compartment is found
+// by reducing its M-value line by the gradient factor, a gf of 1.0 gives the
+// raw M-value ceiling.
+func (m *ZhlModel) ascentCeilingGF(gf float64) float64 {
 	ascentCeil := -(math.MaxFloat64)
 
 	for i, c := range m.compartments {
-		var a, b float64
+		a, b := m.compartCoefs(i)
+		ceil := ((c.pHe + c.pN2) - a*gf) / (gf/b + 1.0 - gf)
+		ascentCeil = math.Max(ascentCeil, ceil)
+	}
+	return m.env.Depth(ascentCeil)
+}
+
+// compartCoefs() returns the a and b values to use for the compartment at index
+// i based on the model's InertGasCoefs setting.
+func (m *ZhlModel) compartCoefs(i int) (float64, float64) {
+	coefs, c := m.coefs[i], m.compartments[i]
+
+	if m.inertGasCoefs == NitrogenCoefs {
 		if m.gasMix.MixType() == gasmix.Heliox {
-			a, b = m.coefs[i].heA, m.coefs[i].heB
-		} else {
-			// For any Nitrogen-based mixes, use the Nitrogen a and b values.
-			// For Trimix, this is more conservative than interpolating the a
-			// and b values based on the pressure of each inert gas in the
-			// compartment.
-			a, b = m.coefs[i].n2A, m.coefs[i].n2B
+			return coefs.heA, coefs.heB
 		}
+		// For any Nitrogen-based mixes, use the Nitrogen a and b values.
+		return coefs.n2A, coefs.n2B
+	}
 
-		ceil := ((c.pHe + c.pN2) - a) * b
-		ascentCeil = math.Max(ascentCeil, ceil)
+	pInert := c.pHe + c.pN2
+	if pInert <= 0.0 {
+		return coefs.n2A, coefs.n2B
 	}
-	return helpers.Depth(ascentCeil)
+
+	a := (coefs.heA*c.pHe + coefs.n2A*c.pN2) / pInert
+	b := (coefs.heB*c.pHe + coefs.n2B*c.pN2) / pInert
+	return a, b
 }
 
-// firstDecompStop() returns the depth in meters rounded up to the
-// nearest multiple of three where the first decompression stop should take
-// place. A zero or negative value means that the diver is within
+// CompartmentLoading represents how close a single tissue compartment is to its
+// M-value. MValue is the maximum tolerated inert gas pressure in bar at the
+// current ambient pressure, PercentMValue is the compartment's inert gas
+// pressure as a percentage of that and GF is the compartment's supersaturation
+// as a percentage of the M-value gradient, that is, the gradient factor it is
+// currently at. A negative GF means that the compartment is on-gassing.
+type CompartmentLoading struct {
+	Compartment   int     `bson:"compartment" json:"compartment"`
+	PInert        float64 `bson:"p_inert" json:"p_inert"`
+	MValue        float64 `bson:"m_value" json:"m_value"`
+	PercentMValue float64 `bson:"percent_m_value" json:"percent_m_value"`
+	GF            float64 `bson:"gf" json:"gf"`
+}
+
+// mValue() returns the M-value in bar for the compartment at index i at the
+// given ambient pressure in bar.
+func (m *ZhlModel) mValue(i int, pamb float64) float64 {
+	a, b := m.compartCoefs(i)
+	return a + pamb/b
+}
+
+// compartmentGF() returns the supersaturation of the compartment at index i as
+// a percentage of its M-value gradient at the given ambient pressure in bar.
+func (m *ZhlModel) compartmentGF(i int, pamb float64) float64 {
+	c := m.compartments[i]
+	return (c.pHe + c.pN2 - pamb) / (m.mValue(i, pamb) - pamb) * 100.0
+}
+
+// CompartmentLoadings() returns the loading of each of the model's tissue
+// compartments relative to their M-values at the current ambient pressure.
+// This can be used to draw a compartment bar chart.
+func (m *ZhlModel) CompartmentLoadings() []CompartmentLoading {
+	loadings := make([]CompartmentLoading, compartCount)
+
+	for i, c := range m.compartments {
+		pInert := c.pHe + c.pN2
+		mv := m.mValue(i, m.currP)
+		loadings[i] = CompartmentLoading{
+			Compartment:   m.coefs[i].n,
+			PInert:        pInert,
+			MValue:        mv,
+			PercentMValue: pInert / mv * 100.0,
+			GF:            m.compartmentGF(i, m.currP),
+		}
+	}
+
+	return loadings
+}
+
+// LeadingCompartment() returns the loading of the compartment that is closest
+// to its M-value at the current ambient pressure, that is, the one with the
+// highest GF.
+func (m *ZhlModel) LeadingCompartment() CompartmentLoading {
+	loadings := m.CompartmentLoadings()
+	leading := loadings[0]
+
+	for _, l := range loadings[1:] {
+		if l.GF > leading.GF {
+			leading = l
+		}
+	}
+
+	return leading
+}
+
+// GF99() returns the current gradient factor as a percentage of the leading
+// compartment's M-value gradient at the current ambient pressure. A negative
+// value means that all compartments are on-gassing.
+func (m *ZhlModel) GF99() float64 {
+	return m.LeadingCompartment().GF
+}
+
+// SurfaceGF() returns the gradient factor as a percentage that the leading
+// compartment would be at if the diver were to surface immediately. A value
+// over 100% means that the diver would exceed the M-value on surfacing.
+func (m *ZhlModel) SurfaceGF() float64 {
+	surfaceP := m.env.Pressure(0.0)
+	surfGF := -(math.MaxFloat64)
+
+	for i := range m.compartments {
+		surfGF = math.Max(surfGF, m.compartmentGF(i, surfaceP))
+	}
+
+	return surfGF
+}
+
+// gfAtDepth() returns the gradient factor that applies at the given depth in
+// metres. It is interpolated linearly between GF low at the depth of the first
+// decompression stop and GF high at the surface.
+func (m *ZhlModel) gfAtDepth(depth, firstStop float64) float64 {
+	if firstStop <= 0.0 || depth <= 0.0 {
+		return m.gfHigh
+	}
+
+	if depth >= firstStop {
+		return m.gfLow
+	}
+
+	return m.gfHigh - (m.gfHigh-m.gfLow)*depth/firstStop
+}
+
+// firstDecompStop() returns the depth in meters rounded up to the nearest
+// multiple of the stop interval where the first decompression stop should take
+// place based on the model's GF low value. It is never shallower than the last
+// stop depth. A zero or negative value means that the diver is within
 // no-decompression limits and can ascend to the surface directly.
 func (m *ZhlModel) firstDecompStop() float64 {
-	return math.Ceil(m.ascentCeiling()/3.0) * 3.0
+	opts := m.decoOpts.withDefaults()
+	firstStop := math.Ceil(m.ascentCeilingGF(m.gfLow)/opts.StopInterval) * opts.StopInterval
+	if firstStop > 0.0 && firstStop < opts.LastStop {
+		return opts.LastStop
+	}
+	return firstStop
 }
 
-// Get the No Decompression Limits (NDLs) by copying the model, then simulating
-// staying at the current pressure in one minute intervals until a positive
-// ascent ceiling is found. The number of iterations is then the NDL value. Up
-// to 60 iterations will be performed, if 60 is returned then it is assumed to
-// be read as 60+ minutes.
+// DefaultMaxNDL is the longest NDL that NDL() will search for when it is
+// given a ceiling of zero.
+const DefaultMaxNDL time.Duration = 24 * time.Hour
+
+// NDLResult represents a No Decompression Limit, see deco.NDLResult.
+type NDLResult = deco.NDLResult
+
+// NDL() returns the No Decompression Limit at the current depth to the nearest
+// second, rounded down. If the diver already has a decompression obligation,
+// then the NDL is zero even if it would clear by staying at the current depth.
+//
+// Otherwise, the NDL is found by bisection on the Schreiner equation rather
+// than by stepping through the dive. Whilst staying at one depth, each
+// compartment's pressure moves steadily towards the inspired inert gas
+// pressure, so the ceiling of a compartment that is off-gassing only ever
+// falls. A positive ceiling can therefore only come from a compartment that is
+// on-gassing, whose ceiling only ever rises, so once the ceiling is positive it
+// stays positive. The search is limited to maxNDL, or DefaultMaxNDL if maxNDL
+// is zero or negative, beyond which the result is reported as having no limit.
+func (m *ZhlModel) NDL(maxNDL time.Duration) NDLResult {
+	if maxNDL <= 0 {
+		maxNDL = DefaultMaxNDL
+	}
+
+	// withinNDL() indicates if the diver can stay at the current depth for the
+	// given number of seconds without a positive ascent ceiling.
+	withinNDL := func(secs int64) bool {
+		ndlModel := m.copyModel()
+		ndlModel.StopCalc(float64(secs) / 60.0)
+		return ndlModel.ascentCeiling() <= 0.0
+	}
+
+	lo, hi := int64(0), int64(maxNDL/time.Second)
+	if !withinNDL(lo) {
+		return NDLResult{}
+	}
+
+	if withinNDL(hi) {
+		return NDLResult{Duration: maxNDL, NoLimit: true}
+	}
+
+	for hi-lo > 1 {
+		mid := (lo + hi) / 2
+		if withinNDL(mid) {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+
+	return NDLResult{Duration: time.Duration(lo) * time.Second}
+}
+
+// Get the No Decompression Limits (NDLs) in whole minutes, rounded down. Up to
+// 60 minutes will be returned, if 60 is returned then it is assumed to be read
+// as 60+ minutes. See NDL() for an uncapped NDL that is accurate to the second.
 func (m *ZhlModel) GetNDL() int {
 	maxNDL := 60
 
@@ -278,42 +627,117 @@ func (m *ZhlModel) GetNDL() int {
 		return maxNDL
 	}
 
-	// Make a copy of the model's compartments data structure so that we do not
-	// overwrite it with data from the NDL calculations.
-	ndlModel := m.copyModel()
-	for i := 0; i <= maxNDL; i++ {
-		ndlModel.StopCalc(1.0)
-		ac := ndlModel.ascentCeiling()
-		if ac > 0.0 {
-			return i
-		}
+	ndl := m.NDL(time.Duration(maxNDL) * time.Minute)
+	return int(ndl.Minutes())
+}
+
+const (
+	// Decompression stop interval in metres for metric stop depths.
+	StopIntervalMetres float64 = 3.0
+	// Decompression stop interval in metres for imperial stop depths (10ft).
+	StopIntervalFeet float64 = 3.048
+	// Tolerance used when comparing stop depths.
+	epsilon float64 = 1e-9
+)
+
+// DecoOptions controls how decompression stops are calculated. StopInterval is
+// the distance in metres between consecutive stops, LastStop is the depth in
+// metres of the last stop which must be either one or two stop intervals deep,
+// and StopResolution is the granularity of the stop lengths in minutes, e.g.
+// 1.0 for whole minutes or 1.0/6.0 for ten seconds. Any zero values use the
+// defaults of 3 metre stops with a last stop at 3 metres and whole minutes.
+type DecoOptions struct {
+	StopInterval   float64 `bson:"stop_interval" json:"stop_interval"`
+	LastStop       float64 `bson:"last_stop" json:"last_stop"`
+	StopResolution float64 `bson:"stop_resolution" json:"stop_resolution"`
+}
+
+// withDefaults() returns a copy of the options with any zero values replaced
+// with their defaults.
+func (o DecoOptions) withDefaults() DecoOptions {
+	if o.StopInterval == 0.0 {
+		o.StopInterval = StopIntervalMetres
 	}
 
-	return maxNDL
+	if o.LastStop == 0.0 {
+		o.LastStop = o.StopInterval
+	}
+
+	if o.StopResolution == 0.0 {
+		o.StopResolution = 1.0
+	}
+
+	return o
 }
 
-// DecompStopLengths() calculates the length of each decompression stop for the
-// model if the dive stopped wherever the model is currently up to. It first
-// calculates the depth of the first stop, then calculates the number of minutes
-// that the diver must stay there until their ascent ceiling is less than or
-// equal to the depth that is 3 metres shallower than that one. This process is
-// repeated up to and including the last stop at 3 metres. If there are no
-// decompression stops required, then an empty slice is returned.
-func (m *ZhlModel) DecompStopLengths(aRate float64) []int {
-	var stops []int
+// SetDecoOptions() sets the options used to calculate decompression stops.
+func (m *ZhlModel) SetDecoOptions(opts DecoOptions) error {
+	o := opts.withDefaults()
+
+	if o.StopInterval < 1.0 || o.StopInterval > 10.0 {
+		return fmt.Errorf("buhlmann: Invalid stop interval (%f), should be between 1.0 and 10.0 inclusive", o.StopInterval)
+	}
 
+	if math.Abs(o.LastStop-o.StopInterval) > epsilon && math.Abs(o.LastStop-2.0*o.StopInterval) > epsilon {
+		return fmt.Errorf("buhlmann: Invalid last stop (%f), should be one or two stop intervals (%f)", o.LastStop, o.StopInterval)
+	}
+
+	if o.StopResolution < 1.0/60.0 || o.StopResolution > 1.0 {
+		return fmt.Errorf("buhlmann: Invalid stop resolution (%f), should be between one second and one minute inclusive", o.StopResolution)
+	}
+
+	m.decoOpts = opts
+	return nil
+}
+
+// DecoOptions() returns the options used to calculate decompression stops with
+// any defaults filled in.
+func (m *ZhlModel) DecoOptions() DecoOptions {
+	return m.decoOpts.withDefaults()
+}
+
+// DecoStop represents a single mandatory decompression stop, see deco.Stop.
+type DecoStop = deco.Stop
+
+// DecoStops() calculates the depth, length and gas mix of each decompression
+// stop for the model if the dive stopped wherever the model is currently up
+// to. It first calculates the depth of the first stop, then calculates how long
+// the diver must stay there, in steps of the stop resolution, until their
+// ascent ceiling is shallower than the depth of the next stop, one stop
+// interval shallower than that one. This process is repeated up to and
+// including the last stop, see SetDecoOptions(). The
+// ceiling at each stop is calculated with the gradient factor for the next
+// stop, interpolated between GF low at the first stop and GF high at the
+// surface. On arrival at each stop, the model switches to the best available
+// decompression gas for that depth, see AddDecoGas(). Stops that are not
+// required are omitted, so if there are no decompression stops required, then
+// an empty slice is returned.
+//
+// With a low GF high and a shallow last stop, the tissues can reach
+// equilibrium at a stop without the ceiling ever clearing the next one. If a
+// stop takes longer than deco.MaxStopTime, then the stops so far are returned
+// with that one capped at deco.MaxStopTime, along with an error.
+func (m *ZhlModel) DecoStops(aRate float64) ([]DecoStop, error) {
+	var stops []DecoStop
+
+	opts := m.decoOpts.withDefaults()
 	firstStop := m.firstDecompStop()
-	lastStop := 3.0
+	lastStop := opts.LastStop
 	model := m.copyModel()
 
-	// If the firstStop value calculated is shallower than the lastStop constant
-	// value then the whole loop is skipped as there are no decompression
+	// If the firstStop value calculated is shallower than the lastStop value
+	// then the whole loop is skipped as there are no decompression
 	// requirements and an empty slice will be returned.
-	for currStop := firstStop; currStop >= lastStop; currStop -= 3.0 {
+	for currStop := firstStop; currStop >= lastStop-epsilon; currStop -= opts.StopInterval {
 		model.TransitionCalc(currStop, aRate)
-		// TODO: Allow different deco gases to be used.
-		nextStop := currStop - 3.0
-		ac := model.ascentCeiling()
+		model.SwitchGas(m.decoGases.Best(currStop, model.gasMix))
+		nextStop := currStop - opts.StopInterval
+		if nextStop < lastStop-epsilon {
+			// The last stop is followed by the ascent to the surface.
+			nextStop = 0.0
+		}
+		gf := m.gfAtDepth(nextStop, firstStop)
+		ac := model.ascentCeilingGF(gf)
 
 		// Check for the case where during the ascent to the current
 		// decompression stop depth, the diver has off-gased sufficiently such
@@ -326,15 +750,51 @@ func (m *ZhlModel) DecompStopLengths(aRate float64) []int {
 			continue
 		}
 
-		stopLength := 0
-		for ac >= nextStop {
-			model.StopCalc(1.0)
-			ac = model.ascentCeiling()
-			stopLength += 1
+		steps := 0
+		for ac >= nextStop && float64(steps)*opts.StopResolution < deco.MaxStopTime {
+			model.StopCalc(opts.StopResolution)
+			ac = model.ascentCeilingGF(gf)
+			steps += 1
 		}
 
-		stops = append(stops, stopLength)
+		stops = append(stops, DecoStop{
+			Depth:    currStop,
+			Duration: float64(steps) * opts.StopResolution,
+			GasMix:   model.gasMix,
+			Runtime:  model.currT,
+		})
+
+		if ac >= nextStop {
+			return stops, fmt.Errorf("buhlmann: Decompression stop at %vm does not clear within %v minutes with GF %.0f/%.0f", currStop, deco.MaxStopTime, m.gfLow*100.0, m.gfHigh*100.0)
+		}
 	}
 
-	return stops
+	return stops, nil
+}
+
+// DecompStopLengths() calculates the length in minutes of each decompression
+// stop for the model if the dive stopped wherever the model is currently up
+// to, see DecoStops() for details. Stop lengths are rounded up to the nearest
+// whole minute. If there are no decompression stops required, then an empty
+// slice is returned. A stop that cannot be completed is capped at
+// deco.MaxStopTime, use DecoStops() to check for this.
+func (m *ZhlModel) DecompStopLengths(aRate float64) []int {
+	stops, _ := m.DecoStops(aRate)
+	return deco.StopLengths(stops)
+}
+
+// TimeToSurface() returns the time in minutes that it would take to ascend
+// from the current depth to the surface at the given ascent rate in m/min,
+// including the time spent at any mandatory decompression stops. An error is
+// returned if the stops cannot be calculated, see DecoStops().
+func (m *ZhlModel) TimeToSurface(aRate float64) (float64, error) {
+	return deco.TimeToSurface(m, m.env.Depth(m.currP), aRate)
+}
+
+// TimeToSurfaceAfter() is like TimeToSurface() but returns the time to surface
+// if the diver were to stay at the current depth for the given additional time
+// in minutes. For example, a time of five minutes gives the "@+5" value shown
+// by many dive computers.
+func (m *ZhlModel) TimeToSurfaceAfter(time, aRate float64) (float64, error) {
+	return deco.TimeToSurfaceAfter(m, m.env.Depth(m.currP), time, aRate)
 }
@@ -5,8 +5,11 @@ package buhlmann
 // https://docs.google.com/spreadsheets/d/1ZXxxTV2FoBjKvZPALfITcl3Y0LoJ_hwVL6Dud_yBwrY/edit#gid=1156961245
 
 import (
+	"math"
 	"testing"
+	"time"
 
+	"github.com/m5lapp/diveplanner/deco"
 	"github.com/m5lapp/diveplanner/gasmix"
 	"github.com/m5lapp/diveplanner/helpers"
 )
@@ -402,6 +405,14 @@ func TestTransitionStopCalc(t *testing.T) {
 	}
 }
 
+// newNitrogenCoefs() returns a new model that uses the Nitrogen a and b values
+// for any mixes other than Heliox.
+func newNitrogenCoefs(gm *gasmix.GasMix, ccs CoefSet) *ZhlModel {
+	m := New(gm, ccs)
+	m.SetInertGasCoefs(NitrogenCoefs)
+	return m
+}
+
 func TestAscentCeilingNDL(t *testing.T) {
 	ean32, _ = gasmix.NewNitroxMix(0.32)
 	trimix2135, _ = gasmix.NewTrimixMix(0.21, 0.35)
@@ -448,7 +459,7 @@ func TestAscentCeilingNDL(t *testing.T) {
 		},
 		{
 			name:    "Trimix2135: 10min @ 26m",
-			m:       New(trimix2135, ZHL16C),
+			m:       newNitrogenCoefs(trimix2135, ZHL16C),
 			dRate:   9,
 			stops:   [2]float64{26.0, 10.0},
 			wantAc:  -0.8575469199,
@@ -456,7 +467,7 @@ func TestAscentCeilingNDL(t *testing.T) {
 		},
 		{
 			name:    "Trimix2135: 20min @ 18m",
-			m:       New(trimix2135, ZHL16C),
+			m:       newNitrogenCoefs(trimix2135, ZHL16C),
 			dRate:   9,
 			stops:   [2]float64{18.0, 20.0},
 			wantAc:  -1.597315895,
@@ -464,7 +475,7 @@ func TestAscentCeilingNDL(t *testing.T) {
 		},
 		{
 			name:    "Trimix2135: 45min @ 12m",
-			m:       New(trimix2135, ZHL16C),
+			m:       newNitrogenCoefs(trimix2135, ZHL16C),
 			dRate:   9,
 			stops:   [2]float64{12.0, 45.0},
 			wantAc:  -1.933904326,
@@ -472,12 +483,44 @@ func TestAscentCeilingNDL(t *testing.T) {
 		},
 		{
 			name:    "Trimix2135: 27min @ 24m",
-			m:       New(trimix2135, ZHL16C),
+			m:       newNitrogenCoefs(trimix2135, ZHL16C),
 			dRate:   9,
 			stops:   [2]float64{24.0, 27.0},
 			wantAc:  2.166049527,
 			wantNdl: 0,
 		},
+		{
+			name:    "Trimix2135: 10min @ 26m, weighted coefs",
+			m:       New(trimix2135, ZHL16C),
+			dRate:   9,
+			stops:   [2]float64{26.0, 10.0},
+			wantAc:  -2.366412716,
+			wantNdl: 8,
+		},
+		{
+			name:    "Trimix2135: 20min @ 18m, weighted coefs",
+			m:       New(trimix2135, ZHL16C),
+			dRate:   9,
+			stops:   [2]float64{18.0, 20.0},
+			wantAc:  -2.901085285,
+			wantNdl: 30,
+		},
+		{
+			name:    "Trimix2135: 45min @ 12m, weighted coefs",
+			m:       New(trimix2135, ZHL16C),
+			dRate:   9,
+			stops:   [2]float64{12.0, 45.0},
+			wantAc:  -2.979016311,
+			wantNdl: 60,
+		},
+		{
+			name:    "Trimix2135: 27min @ 24m, weighted coefs",
+			m:       New(trimix2135, ZHL16C),
+			dRate:   9,
+			stops:   [2]float64{24.0, 27.0},
+			wantAc:  0.6813593831,
+			wantNdl: 0,
+		},
 	}
 
 	for _, tt := range tests {
@@ -542,12 +585,20 @@ func TestDecompStopLengths(t *testing.T) {
 		},
 		{
 			name:  "Trimix2135: 22min @ 45m",
-			m:     New(trimix2135, ZHL16B),
+			m:     newNitrogenCoefs(trimix2135, ZHL16B),
 			dRate: 20.0,
 			aRate: 9.0,
 			stops: [2]float64{45.0, 22.0},
 			want:  []int{1, 4, 10, 22},
 		},
+		{
+			name:  "Trimix2135: 22min @ 45m, weighted coefs",
+			m:     New(trimix2135, ZHL16B),
+			dRate: 20.0,
+			aRate: 9.0,
+			stops: [2]float64{45.0, 22.0},
+			want:  []int{2, 7, 15},
+		},
 	}
 
 	for _, tt := range tests {
@@ -572,3 +623,574 @@ func TestDecompStopLengths(t *testing.T) {
 		})
 	}
 }
+
+func TestSetGradientFactors(t *testing.T) {
+	tests := []struct {
+		name    string
+		gfLow   float64
+		gfHigh  float64
+		wantErr bool
+	}{
+		{name: "GF 100/100", gfLow: 1.0, gfHigh: 1.0, wantErr: false},
+		{name: "GF 30/85", gfLow: 0.3, gfHigh: 0.85, wantErr: false},
+		{name: "GF 0/85", gfLow: 0.0, gfHigh: 0.85, wantErr: true},
+		{name: "GF 30/110", gfLow: 0.3, gfHigh: 1.1, wantErr: true},
+		{name: "GF 90/70", gfLow: 0.9, gfHigh: 0.7, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(air, ZHL16C)
+			err := m.SetGradientFactors(tt.gfLow, tt.gfHigh)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("want error: %v; got: %v", tt.wantErr, err)
+			}
+
+			gfLow, gfHigh := m.GradientFactors()
+			if !tt.wantErr && (gfLow != tt.gfLow || gfHigh != tt.gfHigh) {
+				t.Errorf("want: %f/%f; got: %f/%f", tt.gfLow, tt.gfHigh, gfLow, gfHigh)
+			}
+
+			if tt.wantErr && (gfLow != 1.0 || gfHigh != 1.0) {
+				t.Errorf("invalid values applied: got: %f/%f", gfLow, gfHigh)
+			}
+		})
+	}
+}
+
+func TestGradientFactorDecompStopLengths(t *testing.T) {
+	ean32, _ = gasmix.NewNitroxMix(0.32)
+
+	tests := []struct {
+		name      string
+		gfLow     float64
+		gfHigh    float64
+		stops     [2]float64
+		wantFirst float64
+		want      []int
+	}{
+		{
+			name:      "GF 100/100: 60min @ 30m",
+			gfLow:     1.0,
+			gfHigh:    1.0,
+			stops:     [2]float64{30.0, 60.0},
+			wantFirst: 6.0,
+			want:      []int{1, 15},
+		},
+		{
+			name:      "GF 30/85: 60min @ 30m",
+			gfLow:     0.3,
+			gfHigh:    0.85,
+			stops:     [2]float64{30.0, 60.0},
+			wantFirst: 12.0,
+			want:      []int{4, 7, 16},
+		},
+		{
+			name:      "GF 50/80: 60min @ 30m",
+			gfLow:     0.5,
+			gfHigh:    0.8,
+			stops:     [2]float64{30.0, 60.0},
+			wantFirst: 9.0,
+			want:      []int{3, 9, 19},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(ean32, ZHL16B)
+			if err := m.SetGradientFactors(tt.gfLow, tt.gfHigh); err != nil {
+				t.Fatal(err)
+			}
+			m.TransitionCalc(tt.stops[0], 20.0)
+			m.StopCalc(tt.stops[1])
+
+			if fs := m.firstDecompStop(); fs != tt.wantFirst {
+				t.Errorf("first stop want: %f; got: %f", tt.wantFirst, fs)
+			}
+
+			dsl := m.DecompStopLengths(9.0)
+			if !equalIntSlice(dsl, tt.want) {
+				t.Errorf("want: %v; got: %v", tt.want, dsl)
+			}
+		})
+	}
+}
+
+func TestDecoGases(t *testing.T) {
+	ean32, _ = gasmix.NewNitroxMix(0.32)
+	trimix2135, _ = gasmix.NewTrimixMix(0.21, 0.35)
+	ean50, _ := gasmix.NewNitroxMix(0.50)
+	oxygen, _ := gasmix.NewNitroxMix(1.0)
+
+	tests := []struct {
+		name      string
+		m         *ZhlModel
+		decoGases []*gasmix.GasMix
+		stops     [2]float64
+		want      []int
+	}{
+		{
+			name:      "EAN32: 60min @ 30m, EAN50 + O2",
+			m:         New(ean32, ZHL16B),
+			decoGases: []*gasmix.GasMix{ean50, oxygen},
+			stops:     [2]float64{30.0, 60.0},
+			want:      []int{1, 8},
+		},
+		{
+			name:      "Trimix2135: 22min @ 45m, EAN50",
+			m:         newNitrogenCoefs(trimix2135, ZHL16B),
+			decoGases: []*gasmix.GasMix{ean50},
+			stops:     [2]float64{45.0, 22.0},
+			want:      []int{1, 2, 6, 10},
+		},
+		{
+			name:      "Trimix2135: 22min @ 45m, EAN50 + O2",
+			m:         newNitrogenCoefs(trimix2135, ZHL16B),
+			decoGases: []*gasmix.GasMix{ean50, oxygen},
+			stops:     [2]float64{45.0, 22.0},
+			want:      []int{1, 2, 5, 7},
+		},
+		{
+			name:      "Trimix2135: 22min @ 45m, EAN50 + O2, weighted coefs",
+			m:         New(trimix2135, ZHL16B),
+			decoGases: []*gasmix.GasMix{ean50, oxygen},
+			stops:     [2]float64{45.0, 22.0},
+			want:      []int{2, 3, 7},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bottomGas := tt.m.GasMix()
+			for _, gm := range tt.decoGases {
+				if err := tt.m.AddDecoGas(gm, 1.6); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			tt.m.TransitionCalc(tt.stops[0], 20.0)
+			tt.m.StopCalc(tt.stops[1])
+
+			dsl := tt.m.DecompStopLengths(9.0)
+			if !equalIntSlice(dsl, tt.want) {
+				t.Errorf("want: %v; got: %v", tt.want, dsl)
+			}
+
+			// Check that the main model is still using the bottom gas.
+			if tt.m.GasMix() != bottomGas {
+				t.Errorf("model gas mix changed: want: %v; got: %v", bottomGas, tt.m.GasMix())
+			}
+		})
+	}
+
+	t.Run("Invalid deco gases", func(t *testing.T) {
+		m := New(air, ZHL16C)
+		if err := m.AddDecoGas(nil, 1.6); err == nil {
+			t.Errorf("want error for nil gas mix; got nil")
+		}
+		if err := m.AddDecoGas(ean50, 1.8); err == nil {
+			t.Errorf("want error for max PPO2 of 1.8; got nil")
+		}
+	})
+}
+
+func TestAltitude(t *testing.T) {
+	ean32, _ = gasmix.NewNitroxMix(0.32)
+
+	tests := []struct {
+		name     string
+		env      helpers.Environment
+		wantNdl  int
+		wantSurf float64
+	}{
+		{name: "Sea-level", env: helpers.DefaultEnvironment(), wantNdl: 24, wantSurf: 1.0},
+		{name: "1000m", env: helpers.NewAltitudeEnvironment(1000.0), wantNdl: 17, wantSurf: 0.8869929477},
+		{name: "2000m", env: helpers.NewAltitudeEnvironment(2000.0), wantNdl: 13, wantSurf: 0.7845565994},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewWithEnvironment(ean32, ZHL16B, tt.env)
+			if !helpers.EqualFloat64(math.Round(m.currP*1e10)/1e10, tt.wantSurf) {
+				t.Errorf("surface pressure want: %f; got: %f", tt.wantSurf, m.currP)
+			}
+
+			for i, c := range m.compartments {
+				want := 0.79 * (m.currP - pH2O)
+				if !helpers.EqualFloat64(c.pN2, want) {
+					t.Errorf("compartment %d pN2 want: %f; got: %f", i+1, want, c.pN2)
+				}
+			}
+
+			m.TransitionCalc(24.0, 20.0)
+			m.StopCalc(25.0)
+			if ndl := m.GetNDL(); ndl != tt.wantNdl {
+				t.Errorf("NDL want: %d; got: %d", tt.wantNdl, ndl)
+			}
+		})
+	}
+}
+
+func TestAcclimatise(t *testing.T) {
+	env := helpers.NewAltitudeEnvironment(2000.0)
+	acclimatised := NewWithEnvironment(air, ZHL16C, env)
+	arriving := NewWithEnvironment(air, ZHL16C, env)
+	arriving.Acclimatise(atmPressure, 60.0)
+
+	if arriving.currT != 0.0 {
+		t.Errorf("currT want: %f; got: %f", 0.0, arriving.currT)
+	}
+
+	if arriving.currP != acclimatised.currP {
+		t.Errorf("currP want: %f; got: %f", acclimatised.currP, arriving.currP)
+	}
+
+	if arriving.GasMix() != air {
+		t.Errorf("gas mix changed: want: %v; got: %v", air, arriving.GasMix())
+	}
+
+	// After an hour, the fast compartments should be close to saturation at
+	// altitude, but the slow ones should still hold more Nitrogen.
+	sea := 0.79 * (atmPressure - pH2O)
+	for _, i := range []int{0, 15} {
+		c1, c2 := acclimatised.compartments[i], arriving.compartments[i]
+		if c2.pN2 <= c1.pN2 || c2.pN2 >= sea {
+			t.Errorf("compartment %d pN2 want between %f and %f; got: %f", i+1, c1.pN2, sea, c2.pN2)
+		}
+	}
+}
+
+func TestTimeToSurface(t *testing.T) {
+	ean32, _ = gasmix.NewNitroxMix(0.32)
+
+	tests := []struct {
+		name        string
+		stops       [2]float64
+		wantTTS     float64
+		wantAtPlus5 float64
+	}{
+		{"Surface", [2]float64{0.0, 0.0}, 0.0, 0.0},
+		{"EAN32: 25min @ 30m", [2]float64{30.0, 25.0}, 3.3333, 3.3333},
+		{"EAN32: 60min @ 30m", [2]float64{30.0, 60.0}, 18.3333, 21.3333},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(ean32, ZHL16C)
+			m.TransitionCalc(tt.stops[0], 20.0)
+			m.StopCalc(tt.stops[1])
+			tissues := m.TissuePressures()
+
+			tts, err := m.TimeToSurface(9.0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tts = math.Round(tts*10000.0) / 10000.0; tts != tt.wantTTS {
+				t.Errorf("TTS want: %f; got: %f", tt.wantTTS, tts)
+			}
+
+			atPlus5, err := m.TimeToSurfaceAfter(5.0, 9.0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if atPlus5 = math.Round(atPlus5*10000.0) / 10000.0; atPlus5 != tt.wantAtPlus5 {
+				t.Errorf("@+5 want: %f; got: %f", tt.wantAtPlus5, atPlus5)
+			}
+
+			// Check that the model itself has not been changed.
+			for i, p := range m.TissuePressures() {
+				if p != tissues[i] {
+					t.Errorf("compartment %d changed: want: %f; got: %f", i, tissues[i], p)
+				}
+			}
+		})
+	}
+}
+
+func TestNDL(t *testing.T) {
+	ean32, _ = gasmix.NewNitroxMix(0.32)
+
+	tests := []struct {
+		name   string
+		stops  [2]float64
+		maxNDL time.Duration
+		want   NDLResult
+	}{
+		{
+			name:  "EAN32: 20min @ 30m",
+			stops: [2]float64{30.0, 20.0},
+			want:  NDLResult{Duration: 6*time.Minute + 23*time.Second},
+		},
+		{
+			name:  "EAN32: 30min @ 30m",
+			stops: [2]float64{30.0, 30.0},
+			want:  NDLResult{},
+		},
+		{
+			name:  "EAN32: 25min @ 24m",
+			stops: [2]float64{24.0, 25.0},
+			want:  NDLResult{Duration: 24*time.Minute + 23*time.Second},
+		},
+		{
+			name:  "EAN32: 1min @ 18m",
+			stops: [2]float64{18.0, 1.0},
+			want:  NDLResult{Duration: time.Hour + 59*time.Minute + 42*time.Second},
+		},
+		{
+			name:   "EAN32: 1min @ 18m, 60min max",
+			stops:  [2]float64{18.0, 1.0},
+			maxNDL: time.Hour,
+			want:   NDLResult{Duration: time.Hour, NoLimit: true},
+		},
+		{
+			name:  "EAN32: 1min @ 5m",
+			stops: [2]float64{5.0, 1.0},
+			want:  NDLResult{Duration: DefaultMaxNDL, NoLimit: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(ean32, ZHL16B)
+			m.TransitionCalc(tt.stops[0], 20.0)
+			m.StopCalc(tt.stops[1])
+
+			ndl := m.NDL(tt.maxNDL)
+			if ndl != tt.want {
+				t.Errorf("want: %v; got: %v", tt.want, ndl)
+			}
+		})
+	}
+
+	// Holding a shallow stop whilst in deco would clear the obligation, but
+	// the diver is not within the NDL until it has cleared.
+	t.Run("EAN32: 60min @ 30m, then 6m in deco", func(t *testing.T) {
+		m := New(ean32, ZHL16B)
+		m.TransitionCalc(30.0, 20.0)
+		m.StopCalc(60.0)
+		m.TransitionCalc(6.0, 9.0)
+
+		if stops := m.DecompStopLengths(9.0); len(stops) == 0 {
+			t.Fatal("want decompression stops; got none")
+		}
+
+		if ndl := m.NDL(0); ndl != (NDLResult{}) {
+			t.Errorf("want: %v; got: %v", NDLResult{}, ndl)
+		}
+
+		if ndl := m.GetNDL(); ndl != 0 {
+			t.Errorf("GetNDL() want: 0; got: %d", ndl)
+		}
+	})
+}
+
+func TestCompartmentLoadings(t *testing.T) {
+	ean32, _ = gasmix.NewNitroxMix(0.32)
+
+	// round() rounds a percentage to two decimal places.
+	round := func(v float64) float64 {
+		return math.Round(v*100.0) / 100.0
+	}
+
+	tests := []struct {
+		name           string
+		stops          [2]float64
+		wantLeading    int
+		wantPercentM   float64
+		wantGF99       float64
+		wantSurfaceGF  float64
+		wantSurfacedGF float64
+	}{
+		{"Surface", [2]float64{0.0, 0.0}, 1, 23.37, -11.97, -11.97, -11.97},
+		{"EAN32: 20min @ 30m", [2]float64{30.0, 20.0}, 1, 29.5, -28.12, 88.28, 79.36},
+		{"EAN32: 30min @ 30m", [2]float64{30.0, 30.0}, 1, 30.0, -27.22, 106.26, 98.06},
+		{"EAN32: 60min @ 30m", [2]float64{30.0, 60.0}, 1, 30.1, -27.03, 142.94, 137.1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(ean32, ZHL16B)
+			m.TransitionCalc(tt.stops[0], 20.0)
+			m.StopCalc(tt.stops[1])
+
+			loadings := m.CompartmentLoadings()
+			if len(loadings) != compartCount {
+				t.Fatalf("want %d compartments; got: %d", compartCount, len(loadings))
+			}
+
+			l := m.LeadingCompartment()
+			if l.Compartment != tt.wantLeading || round(l.PercentMValue) != tt.wantPercentM {
+				t.Errorf("leading compartment want: %d, %f; got: %d, %f",
+					tt.wantLeading, tt.wantPercentM, l.Compartment, round(l.PercentMValue))
+			}
+
+			if gf99 := round(m.GF99()); gf99 != tt.wantGF99 {
+				t.Errorf("GF99 want: %f; got: %f", tt.wantGF99, gf99)
+			}
+
+			if surfGF := round(m.SurfaceGF()); surfGF != tt.wantSurfaceGF {
+				t.Errorf("surface GF want: %f; got: %f", tt.wantSurfaceGF, surfGF)
+			}
+
+			// Once at the surface, GF99 and the surface GF should be equal.
+			m.TransitionCalc(0.0, 9.0)
+			if gf99, surfGF := round(m.GF99()), round(m.SurfaceGF()); gf99 != tt.wantSurfacedGF || surfGF != tt.wantSurfacedGF {
+				t.Errorf("surfaced GF want: %f; got: %f, %f", tt.wantSurfacedGF, gf99, surfGF)
+			}
+		})
+	}
+}
+
+func TestDecoOptions(t *testing.T) {
+	ean32, _ = gasmix.NewNitroxMix(0.32)
+
+	tests := []struct {
+		name  string
+		opts  DecoOptions
+		want  []DecoStop
+		wantL []int
+	}{
+		{
+			name: "Defaults",
+			opts: DecoOptions{},
+			want: []DecoStop{
+				{Depth: 6.0, Duration: 1.0, Runtime: 65.1667},
+				{Depth: 3.0, Duration: 15.0, Runtime: 80.5},
+			},
+			wantL: []int{1, 15},
+		},
+		{
+			name: "Last stop at 6m",
+			opts: DecoOptions{LastStop: 6.0},
+			want: []DecoStop{
+				{Depth: 6.0, Duration: 20.0, Runtime: 84.1667},
+			},
+			wantL: []int{20},
+		},
+		{
+			name: "10ft stop interval",
+			opts: DecoOptions{StopInterval: StopIntervalFeet},
+			want: []DecoStop{
+				{Depth: 3.048, Duration: 16.0, Runtime: 80.4947},
+			},
+			wantL: []int{16},
+		},
+		{
+			name: "10 second resolution",
+			opts: DecoOptions{StopResolution: 1.0 / 6.0},
+			want: []DecoStop{
+				{Depth: 6.0, Duration: 0.1667, Runtime: 64.3333},
+				{Depth: 3.0, Duration: 15.0, Runtime: 79.6667},
+			},
+			wantL: []int{1, 15},
+		},
+		{
+			name: "Last stop at 6m, 30 second resolution",
+			opts: DecoOptions{LastStop: 6.0, StopResolution: 0.5},
+			want: []DecoStop{
+				{Depth: 6.0, Duration: 19.5, Runtime: 83.6667},
+			},
+			wantL: []int{20},
+		},
+	}
+
+	// round() rounds a value to four decimal places.
+	round := func(v float64) float64 {
+		return math.Round(v*10000.0) / 10000.0
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(ean32, ZHL16B)
+			if err := m.SetDecoOptions(tt.opts); err != nil {
+				t.Fatal(err)
+			}
+			m.TransitionCalc(30.0, 20.0)
+			m.StopCalc(60.0)
+
+			stops, err := m.DecoStops(9.0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stops) != len(tt.want) {
+				t.Fatalf("want: %v; got: %v", tt.want, stops)
+			}
+
+			for i, s := range stops {
+				got := DecoStop{Depth: round(s.Depth), Duration: round(s.Duration), Runtime: round(s.Runtime)}
+				if got != tt.want[i] || s.GasMix != ean32 {
+					t.Errorf("stop %d want: %v; got: %v", i, tt.want[i], got)
+				}
+			}
+
+			if dsl := m.DecompStopLengths(9.0); !equalIntSlice(dsl, tt.wantL) {
+				t.Errorf("stop lengths want: %v; got: %v", tt.wantL, dsl)
+			}
+		})
+	}
+
+	invalid := []DecoOptions{
+		{StopInterval: 0.5},
+		{StopInterval: 12.0},
+		{LastStop: 4.5},
+		{LastStop: 9.0},
+		{StopInterval: StopIntervalFeet, LastStop: 3.0},
+		{StopResolution: 1.0 / 120.0},
+		{StopResolution: 2.0},
+	}
+
+	for _, opts := range invalid {
+		m := New(ean32, ZHL16B)
+		if err := m.SetDecoOptions(opts); err == nil {
+			t.Errorf("want error for %v; got: nil", opts)
+		}
+
+		if m.DecoOptions() != (DecoOptions{3.0, 3.0, 1.0}) {
+			t.Errorf("options changed to: %v", m.DecoOptions())
+		}
+	}
+}
+
+func TestDecoStopsNotClearing(t *testing.T) {
+	tests := []struct {
+		name   string
+		depth  float64
+		time   float64
+		gfLow  float64
+		gfHigh float64
+	}{
+		{name: "GF 30/40 60min @ 40m", depth: 40.0, time: 60.0, gfLow: 0.3, gfHigh: 0.4},
+		{name: "GF 30/30 60min @ 40m", depth: 40.0, time: 60.0, gfLow: 0.3, gfHigh: 0.3},
+		{name: "GF 20/20 40min @ 30m", depth: 30.0, time: 40.0, gfLow: 0.2, gfHigh: 0.2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// With a last stop at 6m, the tissues reach equilibrium on air
+			// before the ceiling with the low GF high clears the surface.
+			m := New(air, ZHL16C)
+			if err := m.SetGradientFactors(tt.gfLow, tt.gfHigh); err != nil {
+				t.Fatal(err)
+			}
+			if err := m.SetDecoOptions(DecoOptions{LastStop: 6.0}); err != nil {
+				t.Fatal(err)
+			}
+			m.TransitionCalc(tt.depth, 20.0)
+			m.StopCalc(tt.time)
+
+			stops, err := m.DecoStops(9.0)
+			if err == nil {
+				t.Fatalf("want an error; got stops: %v", stops)
+			}
+
+			last := stops[len(stops)-1]
+			if last.Depth != 6.0 || last.Duration != deco.MaxStopTime {
+				t.Errorf("want the 6m stop capped at %v minutes; got: %v", deco.MaxStopTime, last)
+			}
+
+			if _, err := m.TimeToSurface(9.0); err == nil {
+				t.Error("want a time to surface error")
+			}
+		})
+	}
+}
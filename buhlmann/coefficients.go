@@ -0,0 +1,158 @@
+package buhlmann
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/m5lapp/diveplanner/gasmix"
+	"github.com/m5lapp/diveplanner/helpers"
+)
+
+// CompartmentCoefs holds the coefficients of a single tissue compartment; its
+// Nitrogen and Helium half-times in minutes and the a and b values used to
+// calculate its M-value for each gas.
+type CompartmentCoefs struct {
+	N2HalfTime float64 `bson:"n2_half_time" json:"n2_half_time"`
+	N2A        float64 `bson:"n2_a" json:"n2_a"`
+	N2B        float64 `bson:"n2_b" json:"n2_b"`
+	HeHalfTime float64 `bson:"he_half_time" json:"he_half_time"`
+	HeA        float64 `bson:"he_a" json:"he_a"`
+	HeB        float64 `bson:"he_b" json:"he_b"`
+}
+
+// CoefTable is a named table of coefficients with one entry for each of the
+// model's 16 compartments, ordered from the fastest to the slowest. It can be
+// used to run the model with coefficients other than the built-in ZH-L16 sets,
+// such as ZH-L12 or modified research sets.
+type CoefTable struct {
+	Name         string             `bson:"name" json:"name"`
+	Compartments []CompartmentCoefs `bson:"compartments" json:"compartments"`
+}
+
+// Table() returns a copy of the coefficient set as a CoefTable, for instance,
+// as a starting point for a modified set.
+func (ccs CoefSet) Table() *CoefTable {
+	t := &CoefTable{
+		Name:         ccs.String(),
+		Compartments: make([]CompartmentCoefs, compartCount),
+	}
+
+	for i, c := range compartCoefSets[ccs] {
+		t.Compartments[i] = CompartmentCoefs{
+			N2HalfTime: c.n2Ht,
+			N2A:        c.n2A,
+			N2B:        c.n2B,
+			HeHalfTime: c.heHt,
+			HeA:        c.heA,
+			HeB:        c.heB,
+		}
+	}
+
+	return t
+}
+
+// Validate() checks that the table has an entry for each of the 16
+// compartments, that the half-times for each gas are positive and strictly
+// increasing from one compartment to the next and that the a and b values are
+// usable.
+func (t *CoefTable) Validate() error {
+	if len(t.Compartments) != compartCount {
+		return fmt.Errorf("buhlmann: Invalid number of compartment coefficients (%d), should be %d", len(t.Compartments), compartCount)
+	}
+
+	var prevN2Ht, prevHeHt float64
+	for i, c := range t.Compartments {
+		if c.N2HalfTime <= prevN2Ht {
+			return fmt.Errorf("buhlmann: Invalid Nitrogen half-time for compartment %d (%f), should be greater than %f", i+1, c.N2HalfTime, prevN2Ht)
+		}
+
+		if c.HeHalfTime <= prevHeHt {
+			return fmt.Errorf("buhlmann: Invalid Helium half-time for compartment %d (%f), should be greater than %f", i+1, c.HeHalfTime, prevHeHt)
+		}
+
+		if c.N2A < 0.0 || c.HeA < 0.0 {
+			return fmt.Errorf("buhlmann: Invalid a values for compartment %d (%f, %f), should not be negative", i+1, c.N2A, c.HeA)
+		}
+
+		if c.N2B <= 0.0 || c.N2B > 1.0 || c.HeB <= 0.0 || c.HeB > 1.0 {
+			return fmt.Errorf("buhlmann: Invalid b values for compartment %d (%f, %f), should be greater than 0.0 and no more than 1.0", i+1, c.N2B, c.HeB)
+		}
+
+		prevN2Ht, prevHeHt = c.N2HalfTime, c.HeHalfTime
+	}
+
+	return nil
+}
+
+// coefs() converts the table into the form used internally by the model. The
+// table should be validated first.
+func (t *CoefTable) coefs() *[compartCount]compartCoefs {
+	var coefs [compartCount]compartCoefs
+
+	for i, c := range t.Compartments {
+		coefs[i] = compartCoefs{
+			n:    i + 1,
+			n2Ht: c.N2HalfTime,
+			n2A:  c.N2A,
+			n2B:  c.N2B,
+			heHt: c.HeHalfTime,
+			heA:  c.HeA,
+			heB:  c.HeB,
+		}
+	}
+
+	return &coefs
+}
+
+// copy() returns a copy of the table that does not share its compartments.
+func (t *CoefTable) copy() *CoefTable {
+	return &CoefTable{Name: t.Name, Compartments: append([]CompartmentCoefs(nil), t.Compartments...)}
+}
+
+// LoadCoefTable() reads a CoefTable encoded as JSON from r and validates it.
+func LoadCoefTable(r io.Reader) (*CoefTable, error) {
+	var t CoefTable
+
+	if err := json.NewDecoder(r).Decode(&t); err != nil {
+		return nil, fmt.Errorf("buhlmann: Unable to decode coefficient table: %w", err)
+	}
+
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// NewWithCoefTable() is like NewWithEnvironment() but uses the coefficients in
+// the given table instead of one of the built-in coefficient sets. An error is
+// returned if the table is not valid.
+func NewWithCoefTable(gm *gasmix.GasMix, t *CoefTable, env helpers.Environment) (*ZhlModel, error) {
+	if t == nil {
+		return nil, fmt.Errorf("buhlmann: Coefficient table cannot be nil")
+	}
+
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Keep a copy of the table so that later changes to it do not affect the
+	// model.
+	tc := t.copy()
+
+	m := NewWithEnvironment(gm, ZHL16C, env)
+	m.coefs = tc.coefs()
+	m.coefTable = tc
+
+	return m, nil
+}
+
+// CoefTable() returns a copy of the coefficients used by the model, either
+// those of its built-in coefficient set or its custom table.
+func (m *ZhlModel) CoefTable() *CoefTable {
+	if m.coefTable != nil {
+		return m.coefTable.copy()
+	}
+	return m.ccs.Table()
+}
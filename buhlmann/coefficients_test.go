@@ -0,0 +1,117 @@
+package buhlmann
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/m5lapp/diveplanner/gasmix"
+	"github.com/m5lapp/diveplanner/helpers"
+)
+
+func TestCoefTable(t *testing.T) {
+	air := gasmix.NewAirMix()
+
+	for _, ccs := range []CoefSet{ZHL16A, ZHL16B, ZHL16C} {
+		t.Run(ccs.String(), func(t *testing.T) {
+			data, err := json.Marshal(ccs.Table())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			table, err := LoadCoefTable(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			m, err := NewWithCoefTable(air, table, helpers.DefaultEnvironment())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := New(air, ccs)
+			for _, model := range []*ZhlModel{m, want} {
+				model.TransitionCalc(40.0, 20.0)
+				model.StopCalc(25.0)
+			}
+
+			if got, w := m.DecompStopLengths(9.0), want.DecompStopLengths(9.0); !equalIntSlice(got, w) {
+				t.Errorf("stops want: %v; got: %v", w, got)
+			}
+
+			if m.CoefTable().Name != ccs.String() {
+				t.Errorf("name want: %s; got: %s", ccs, m.CoefTable().Name)
+			}
+		})
+	}
+
+	t.Run("Modified a values", func(t *testing.T) {
+		// Reducing the a values lowers the M-values which should shorten the
+		// NDL.
+		table := ZHL16C.Table()
+		table.Name = "ZH-L16C 90% a"
+		for i := range table.Compartments {
+			table.Compartments[i].N2A *= 0.9
+		}
+
+		m, err := NewWithCoefTable(air, table, helpers.DefaultEnvironment())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := New(air, ZHL16C)
+		m.TransitionCalc(30.0, 20.0)
+		want.TransitionCalc(30.0, 20.0)
+		if m.NDL(0).Duration >= want.NDL(0).Duration {
+			t.Errorf("NDL want less than %v; got: %v", want.NDL(0), m.NDL(0))
+		}
+
+		// Changing the table afterwards should not affect the model.
+		table.Compartments[0].N2HalfTime = 0.0
+		if m.CoefTable().Validate() != nil {
+			t.Error("model's table was modified")
+		}
+	})
+}
+
+func TestCoefTableInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(t *CoefTable)
+	}{
+		{"Too few compartments", func(t *CoefTable) { t.Compartments = t.Compartments[:12] }},
+		{"Zero Nitrogen half-time", func(t *CoefTable) { t.Compartments[0].N2HalfTime = 0.0 }},
+		{"Nitrogen half-times not increasing", func(t *CoefTable) { t.Compartments[5].N2HalfTime = t.Compartments[4].N2HalfTime }},
+		{"Helium half-times not increasing", func(t *CoefTable) { t.Compartments[9].HeHalfTime = 1.0 }},
+		{"Negative a value", func(t *CoefTable) { t.Compartments[2].HeA = -0.1 }},
+		{"Zero b value", func(t *CoefTable) { t.Compartments[7].N2B = 0.0 }},
+		{"b value greater than one", func(t *CoefTable) { t.Compartments[15].HeB = 1.1 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := ZHL16B.Table()
+			tt.modify(table)
+
+			if err := table.Validate(); err == nil {
+				t.Error("want error; got: nil")
+			}
+
+			data, _ := json.Marshal(table)
+			if _, err := LoadCoefTable(bytes.NewReader(data)); err == nil {
+				t.Error("load want error; got: nil")
+			}
+
+			if _, err := NewWithCoefTable(gasmix.NewAirMix(), table, helpers.DefaultEnvironment()); err == nil {
+				t.Error("new want error; got: nil")
+			}
+		})
+	}
+
+	t.Run("Invalid JSON", func(t *testing.T) {
+		if _, err := LoadCoefTable(strings.NewReader(`{"compartments": [`)); err == nil {
+			t.Error("want error; got: nil")
+		}
+	})
+}
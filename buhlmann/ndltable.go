@@ -0,0 +1,128 @@
+package buhlmann
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/m5lapp/diveplanner/deco"
+)
+
+// NDLTableOptions configures the depths in metres that are included in an NDL
+// table and how each of them is reached. Depths start at MinDepth and increase
+// by DepthStep up to MaxDepth, which is always included as the last depth.
+// Each depth is reached from the surface at DescentRate in m/min and NDLs are
+// searched for up to MaxNDL, or DefaultMaxNDL if it is zero.
+type NDLTableOptions struct {
+	MinDepth    float64       `bson:"min_depth" json:"min_depth"`
+	MaxDepth    float64       `bson:"max_depth" json:"max_depth"`
+	DepthStep   float64       `bson:"depth_step" json:"depth_step"`
+	DescentRate float64       `bson:"descent_rate" json:"descent_rate"`
+	MaxNDL      time.Duration `bson:"max_ndl" json:"max_ndl"`
+}
+
+// DefaultNDLTableOptions gives an NDL table for every three metres from nine to
+// 40 metres with a descent rate of 18 m/min and NDLs of up to five hours.
+var DefaultNDLTableOptions = NDLTableOptions{
+	MinDepth:    9.0,
+	MaxDepth:    40.0,
+	DepthStep:   3.0,
+	DescentRate: 18.0,
+	MaxNDL:      5 * time.Hour,
+}
+
+// validate() checks that the options describe a usable table.
+func (o NDLTableOptions) validate() error {
+	if o.MinDepth <= 0.0 {
+		return fmt.Errorf("buhlmann: Invalid minimum depth (%f), should be greater than 0.0", o.MinDepth)
+	}
+
+	if o.MaxDepth < o.MinDepth {
+		return fmt.Errorf("buhlmann: Invalid maximum depth (%f), should be at least the minimum depth (%f)", o.MaxDepth, o.MinDepth)
+	}
+
+	if o.DepthStep <= 0.0 {
+		return fmt.Errorf("buhlmann: Invalid depth step (%f), should be greater than 0.0", o.DepthStep)
+	}
+
+	if o.DescentRate <= 0.0 {
+		return fmt.Errorf("buhlmann: Invalid descent rate (%f), should be greater than 0.0", o.DescentRate)
+	}
+
+	return nil
+}
+
+// depths() returns each of the depths in the table.
+func (o NDLTableOptions) depths() []float64 {
+	var depths []float64
+
+	for d := o.MinDepth; d < o.MaxDepth-epsilon; d += o.DepthStep {
+		depths = append(depths, d)
+	}
+
+	return append(depths, o.MaxDepth)
+}
+
+// NDLTableEntry is the No Decompression Limit at a single depth in metres in an
+// NDL table. The NDL is the time that can be spent at the depth after the
+// descent to it, it does not include the descent itself.
+type NDLTableEntry struct {
+	Depth float64   `bson:"depth" json:"depth"`
+	NDL   NDLResult `bson:"ndl" json:"ndl"`
+}
+
+// NDLTable() returns the No Decompression Limit at each of the depths given by
+// the options for a dive starting from the model's current state, which should
+// be at the surface. The model's coefficients, gradient factors, gas mix and
+// environment are used and the model itself is not modified. For example, a
+// table for EAN32 with ZH-L16B at GF 40/85:
+//
+//	m := buhlmann.New(ean32, buhlmann.ZHL16B)
+//	m.SetGradientFactors(0.40, 0.85)
+//	table, err := m.NDLTable(buhlmann.DefaultNDLTableOptions)
+func (m *ZhlModel) NDLTable(opts NDLTableOptions) ([]NDLTableEntry, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	var table []NDLTableEntry
+	for _, depth := range opts.depths() {
+		model := m.copyModel()
+		model.TransitionCalc(depth, opts.DescentRate)
+		table = append(table, NDLTableEntry{Depth: depth, NDL: model.NDL(opts.MaxNDL)})
+	}
+
+	return table, nil
+}
+
+// RepetitiveNDLTable is an NDL table for a repetitive dive that starts after
+// the diver has spent SurfaceInterval minutes at the surface.
+type RepetitiveNDLTable struct {
+	SurfaceInterval float64         `bson:"surface_interval" json:"surface_interval"`
+	Entries         []NDLTableEntry `bson:"entries" json:"entries"`
+}
+
+// RepetitiveNDLTables() returns an NDL table, see NDLTable(), for a repetitive
+// dive after each of the given surface intervals in minutes. The model should
+// be at the surface at the end of the previous dive and the diver is assumed to
+// breathe air during the surface intervals. The model itself is not modified.
+func (m *ZhlModel) RepetitiveNDLTables(surfaceIntervals []float64, opts NDLTableOptions) ([]RepetitiveNDLTable, error) {
+	var tables []RepetitiveNDLTable
+
+	for _, si := range surfaceIntervals {
+		if si < 0.0 {
+			return nil, fmt.Errorf("buhlmann: Invalid surface interval (%f), should not be negative", si)
+		}
+
+		model := m.copyModel()
+		deco.SurfaceInterval(model, si)
+
+		entries, err := model.NDLTable(opts)
+		if err != nil {
+			return nil, err
+		}
+
+		tables = append(tables, RepetitiveNDLTable{SurfaceInterval: si, Entries: entries})
+	}
+
+	return tables, nil
+}
@@ -0,0 +1,122 @@
+package buhlmann
+
+import (
+	"testing"
+	"time"
+
+	"github.com/m5lapp/diveplanner/gasmix"
+)
+
+func TestNDLTable(t *testing.T) {
+	air := gasmix.NewAirMix()
+	ean32, _ := gasmix.NewNitroxMix(0.32)
+	gf4085 := New(air, ZHL16C)
+	gf4085.SetGradientFactors(0.40, 0.85)
+
+	tests := []struct {
+		name  string
+		model *ZhlModel
+		want  map[float64]NDLResult
+	}{
+		{
+			name:  "Air ZHL16C",
+			model: New(air, ZHL16C),
+			want: map[float64]NDLResult{
+				9.0:  {Duration: 5 * time.Hour, NoLimit: true},
+				18.0: {Duration: 63*time.Minute + 41*time.Second},
+				30.0: {Duration: 15*time.Minute + 30*time.Second},
+				40.0: {Duration: 7*time.Minute + 21*time.Second},
+			},
+		}, {
+			name:  "EAN32 ZHL16B",
+			model: New(ean32, ZHL16B),
+			want: map[float64]NDLResult{
+				12.0: {Duration: 5 * time.Hour, NoLimit: true},
+				18.0: {Duration: 2*time.Hour + 39*time.Second},
+				30.0: {Duration: 26*time.Minute + 19*time.Second},
+			},
+		}, {
+			name:  "Air ZHL16C GF 40/85",
+			model: gf4085,
+			want: map[float64]NDLResult{
+				18.0: {Duration: 42*time.Minute + 32*time.Second},
+				30.0: {Duration: 11*time.Minute + 37*time.Second},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table, err := tt.model.NDLTable(DefaultNDLTableOptions)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Every three metres from 9m to 39m, then 40m.
+			if len(table) != 12 || table[0].Depth != 9.0 || table[11].Depth != 40.0 {
+				t.Fatalf("unexpected depths in table: %v", table)
+			}
+
+			for _, e := range table {
+				if want, ok := tt.want[e.Depth]; ok && e.NDL != want {
+					t.Errorf("%vm want: %v; got: %v", e.Depth, want, e.NDL)
+				}
+			}
+
+			if tt.model.currT != 0.0 {
+				t.Error("model was modified")
+			}
+		})
+	}
+}
+
+func TestNDLTableInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		opts NDLTableOptions
+	}{
+		{"Zero minimum depth", NDLTableOptions{MaxDepth: 40.0, DepthStep: 3.0, DescentRate: 18.0}},
+		{"Maximum depth less than minimum", NDLTableOptions{MinDepth: 40.0, MaxDepth: 9.0, DepthStep: 3.0, DescentRate: 18.0}},
+		{"Zero depth step", NDLTableOptions{MinDepth: 9.0, MaxDepth: 40.0, DescentRate: 18.0}},
+		{"Zero descent rate", NDLTableOptions{MinDepth: 9.0, MaxDepth: 40.0, DepthStep: 3.0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(gasmix.NewAirMix(), ZHL16C).NDLTable(tt.opts); err == nil {
+				t.Error("want error; got: nil")
+			}
+		})
+	}
+}
+
+func TestRepetitiveNDLTables(t *testing.T) {
+	m := New(gasmix.NewAirMix(), ZHL16C)
+	m.TransitionCalc(30.0, 18.0)
+	m.StopCalc(20.0)
+	m.TransitionCalc(0.0, 9.0)
+
+	opts := NDLTableOptions{MinDepth: 12.0, MaxDepth: 30.0, DepthStep: 6.0, DescentRate: 18.0}
+	tables, err := m.RepetitiveNDLTables([]float64{30.0, 60.0, 120.0}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]time.Duration{
+		{3*time.Hour + 6*time.Minute + 5*time.Second, 46*time.Minute + 40*time.Second, 23*time.Minute + 34*time.Second, 14*time.Minute + 54*time.Second},
+		{3*time.Hour + 19*time.Minute + 4*time.Second, 56*time.Minute + 16*time.Second, 27*time.Minute + 20*time.Second, 15*time.Minute + 28*time.Second},
+		{3*time.Hour + 34*time.Minute + 26*time.Second, 63*time.Minute + 25*time.Second, 27*time.Minute + 50*time.Second, 15*time.Minute + 30*time.Second},
+	}
+
+	for i, table := range tables {
+		for j, e := range table.Entries {
+			if e.NDL.Duration != want[i][j] {
+				t.Errorf("%vmin SI %vm want: %v; got: %v", table.SurfaceInterval, e.Depth, want[i][j], e.NDL.Duration)
+			}
+		}
+	}
+
+	if _, err := m.RepetitiveNDLTables([]float64{-1.0}, opts); err == nil {
+		t.Error("negative surface interval want error; got: nil")
+	}
+}
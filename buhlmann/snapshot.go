@@ -0,0 +1,151 @@
+package buhlmann
+
+import (
+	"fmt"
+
+	"github.com/m5lapp/diveplanner/gasmix"
+	"github.com/m5lapp/diveplanner/helpers"
+)
+
+// MarshalText() encodes the coefficient set as its name, e.g. "ZH-L16B", so
+// that it remains readable and stable when serialised.
+func (ccs CoefSet) MarshalText() ([]byte, error) {
+	if ccs < ZHL16A || ccs > ZHL16C {
+		return nil, fmt.Errorf("buhlmann: Invalid coefficient set (%d)", int(ccs))
+	}
+	return []byte(ccs.String()), nil
+}
+
+// UnmarshalText() decodes a coefficient set from its name, e.g. "ZH-L16B".
+func (ccs *CoefSet) UnmarshalText(text []byte) error {
+	for _, c := range []CoefSet{ZHL16A, ZHL16B, ZHL16C} {
+		if string(text) == c.String() {
+			*ccs = c
+			return nil
+		}
+	}
+	return fmt.Errorf("buhlmann: Invalid coefficient set (%s), should be one of ZH-L16A, ZH-L16B or ZH-L16C", text)
+}
+
+// CompartmentState represents the pressures in bar of Nitrogen and Helium in a
+// single tissue compartment.
+type CompartmentState struct {
+	PN2 float64 `bson:"pn2" json:"pn2"`
+	PHe float64 `bson:"phe" json:"phe"`
+}
+
+// Snapshot represents the state of a ZhlModel's tissues at a point in time so
+// that it can be stored and the model rebuilt later with NewFromSnapshot(), for
+// instance, to carry on planning repetitive dives. Pressure is the ambient
+// pressure in bar and Time the elapsed time in minutes. CoefTable is only set
+// for models that use a custom coefficient table, in which case it is used
+// instead of CoefSet.
+type Snapshot struct {
+	CoefSet      CoefSet             `bson:"coef_set" json:"coef_set"`
+	CoefTable    *CoefTable          `bson:"coef_table,omitempty" json:"coef_table,omitempty"`
+	Compartments []CompartmentState  `bson:"compartments" json:"compartments"`
+	Pressure     float64             `bson:"pressure" json:"pressure"`
+	Time         float64             `bson:"time" json:"time"`
+	Environment  helpers.Environment `bson:"environment" json:"environment"`
+	// The model's alveolar gas settings, see SetAlveolarGas(). An RQ of zero
+	// is treated as BuhlmannRQ.
+	PACO2 float64 `bson:"paco2" json:"paco2"`
+	RQ    float64 `bson:"rq" json:"rq"`
+}
+
+// Snapshot() returns a snapshot of the model's current tissue state.
+func (m *ZhlModel) Snapshot() *Snapshot {
+	s := &Snapshot{
+		CoefSet:      m.ccs,
+		Compartments: make([]CompartmentState, compartCount),
+		Pressure:     m.currP,
+		Time:         m.currT,
+		Environment:  m.env,
+		PACO2:        m.paco2,
+		RQ:           m.rq,
+	}
+
+	if m.coefTable != nil {
+		s.CoefTable = m.coefTable.copy()
+	}
+
+	for i, c := range m.compartments {
+		s.Compartments[i] = CompartmentState{PN2: c.pN2, PHe: c.pHe}
+	}
+
+	return s
+}
+
+// Validate() checks that the snapshot can be used to rebuild a model.
+func (s *Snapshot) Validate() error {
+	if s.CoefSet < ZHL16A || s.CoefSet > ZHL16C {
+		return fmt.Errorf("buhlmann: Invalid coefficient set (%d)", int(s.CoefSet))
+	}
+
+	if s.CoefTable != nil {
+		if err := s.CoefTable.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if len(s.Compartments) != compartCount {
+		return fmt.Errorf("buhlmann: Invalid number of compartments (%d), should be %d", len(s.Compartments), compartCount)
+	}
+
+	for i, c := range s.Compartments {
+		if c.PN2 < 0.0 || c.PHe < 0.0 {
+			return fmt.Errorf("buhlmann: Invalid pressures for compartment %d (%f, %f), should not be negative", i+1, c.PN2, c.PHe)
+		}
+	}
+
+	if s.Pressure <= 0.0 {
+		return fmt.Errorf("buhlmann: Invalid ambient pressure (%f), should be greater than zero", s.Pressure)
+	}
+
+	if s.Time < 0.0 {
+		return fmt.Errorf("buhlmann: Invalid time (%f), should not be negative", s.Time)
+	}
+
+	if s.PACO2 < 0.0 || s.PACO2 > 0.1 {
+		return fmt.Errorf("buhlmann: Invalid PACO2 value (%f), should be between 0.0 and 0.1", s.PACO2)
+	}
+
+	if s.RQ != 0.0 && (s.RQ < 0.7 || s.RQ > 1.0) {
+		return fmt.Errorf("buhlmann: Invalid respiratory quotient (%f), should be between 0.7 and 1.0", s.RQ)
+	}
+
+	return nil
+}
+
+// NewFromSnapshot() is a constructor that rebuilds a Bühlmann model from the
+// given snapshot with the diver breathing the given gas mix. The model's
+// gradient factors and decompression gases are not part of the snapshot and
+// should be set again if required.
+func NewFromSnapshot(gm *gasmix.GasMix, s *Snapshot) (*ZhlModel, error) {
+	if s == nil {
+		return nil, fmt.Errorf("buhlmann: Snapshot cannot be nil")
+	}
+
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	m := NewWithEnvironment(gm, s.CoefSet, s.Environment)
+	if s.CoefTable != nil {
+		// Keep a copy of the table so that later changes to the snapshot do
+		// not affect the model.
+		m.coefTable = s.CoefTable.copy()
+		m.coefs = m.coefTable.coefs()
+	}
+	m.currP = s.Pressure
+	m.currT = s.Time
+	m.paco2 = s.PACO2
+	if s.RQ != 0.0 {
+		m.rq = s.RQ
+	}
+	for i, c := range s.Compartments {
+		m.compartments[i] = compartModel{pHe: c.PHe, pN2: c.PN2}
+	}
+
+	return m, nil
+}
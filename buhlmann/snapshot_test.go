@@ -0,0 +1,163 @@
+package buhlmann
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/m5lapp/diveplanner/gasmix"
+	"github.com/m5lapp/diveplanner/helpers"
+)
+
+func TestSnapshot(t *testing.T) {
+	ean32, _ := gasmix.NewNitroxMix(0.32)
+	trimix2135, _ := gasmix.NewTrimixMix(0.21, 0.35)
+
+	tests := []struct {
+		name  string
+		m     *ZhlModel
+		stops [2]float64
+	}{
+		{"EAN32 ZHL16B: 20min @ 30m", New(ean32, ZHL16B), [2]float64{30.0, 20.0}},
+		{"Trimix2135 ZHL16C: 22min @ 45m", New(trimix2135, ZHL16C), [2]float64{45.0, 22.0}},
+		{
+			"EAN32 ZHL16C altitude: 25min @ 24m",
+			NewWithEnvironment(ean32, ZHL16C, helpers.NewAltitudeEnvironment(1000.0)),
+			[2]float64{24.0, 25.0},
+		},
+		{"EAN32 ZHL16C Schreiner RQ: 20min @ 30m", newSchreinerRQ(ean32), [2]float64{30.0, 20.0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.m.TransitionCalc(tt.stops[0], 20.0)
+			tt.m.StopCalc(tt.stops[1])
+
+			data, err := json.Marshal(tt.m.Snapshot())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var s Snapshot
+			if err := json.Unmarshal(data, &s); err != nil {
+				t.Fatal(err)
+			}
+
+			m, err := NewFromSnapshot(tt.m.GasMix(), &s)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if m.paco2 != tt.m.paco2 || m.rq != tt.m.rq {
+				t.Errorf("alveolar gas want: %f, %f; got: %f, %f", tt.m.paco2, tt.m.rq, m.paco2, m.rq)
+			}
+
+			if m.ccs != tt.m.ccs || m.currP != tt.m.currP || m.currT != tt.m.currT || m.env != tt.m.env {
+				t.Errorf("want: %v %f %f %v; got: %v %f %f %v",
+					tt.m.ccs, tt.m.currP, tt.m.currT, tt.m.env,
+					m.ccs, m.currP, m.currT, m.env)
+			}
+
+			for i, c := range m.compartments {
+				if c != tt.m.compartments[i] {
+					t.Errorf("compartment %d want: %v; got: %v", i+1, tt.m.compartments[i], c)
+				}
+			}
+
+			if m.NDL(0) != tt.m.NDL(0) {
+				t.Errorf("NDL want: %v; got: %v", tt.m.NDL(0), m.NDL(0))
+			}
+		})
+	}
+
+	t.Run("Custom coefficient table", func(t *testing.T) {
+		table := ZHL16C.Table()
+		for i := range table.Compartments {
+			table.Compartments[i].N2B *= 0.95
+		}
+
+		m, _ := NewWithCoefTable(ean32, table, helpers.DefaultEnvironment())
+		m.TransitionCalc(30.0, 20.0)
+		m.StopCalc(30.0)
+
+		data, _ := json.Marshal(m.Snapshot())
+		var s Snapshot
+		if err := json.Unmarshal(data, &s); err != nil {
+			t.Fatal(err)
+		}
+
+		restored, err := NewFromSnapshot(ean32, &s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, got := m.DecompStopLengths(9.0), restored.DecompStopLengths(9.0); !equalIntSlice(want, got) {
+			t.Errorf("stops want: %v; got: %v", want, got)
+		}
+
+		// Changing the snapshot's table afterwards must not affect the
+		// restored model.
+		want := restored.CoefTable().Compartments[0].N2B
+		s.CoefTable.Compartments[0].N2B = 0.1
+		if got := restored.CoefTable().Compartments[0].N2B; got != want {
+			t.Errorf("restored N2B want: %v; got: %v", want, got)
+		}
+	})
+
+	t.Run("Coefficient set name", func(t *testing.T) {
+		data, _ := json.Marshal(New(ean32, ZHL16B).Snapshot())
+		if !strings.Contains(string(data), `"coef_set":"ZH-L16B"`) {
+			t.Errorf("coefficient set name not found in %s", data)
+		}
+	})
+}
+
+// newSchreinerRQ() returns a new ZHL16C model that uses Schreiner's alveolar gas
+// settings.
+func newSchreinerRQ(gm *gasmix.GasMix) *ZhlModel {
+	m := New(gm, ZHL16C)
+	m.SetAlveolarGas(DefaultPACO2, SchreinerRQ)
+	return m
+}
+
+func TestNewFromSnapshotInvalid(t *testing.T) {
+	ean32, _ := gasmix.NewNitroxMix(0.32)
+
+	tests := []struct {
+		name   string
+		modify func(s *Snapshot)
+	}{
+		{"Invalid coefficient set", func(s *Snapshot) { s.CoefSet = 3 }},
+		{"Too few compartments", func(s *Snapshot) { s.Compartments = s.Compartments[:15] }},
+		{"Negative pN2", func(s *Snapshot) { s.Compartments[3].PN2 = -0.1 }},
+		{"Negative pHe", func(s *Snapshot) { s.Compartments[15].PHe = -0.1 }},
+		{"Zero pressure", func(s *Snapshot) { s.Pressure = 0.0 }},
+		{"Negative time", func(s *Snapshot) { s.Time = -1.0 }},
+		{"Invalid coefficient table", func(s *Snapshot) { s.CoefTable = &CoefTable{} }},
+		{"Invalid PACO2", func(s *Snapshot) { s.PACO2 = -0.1 }},
+		{"Invalid RQ", func(s *Snapshot) { s.RQ = 1.5 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(ean32, ZHL16C).Snapshot()
+			tt.modify(s)
+			if _, err := NewFromSnapshot(ean32, s); err == nil {
+				t.Error("want error; got: nil")
+			}
+		})
+	}
+
+	t.Run("Nil snapshot", func(t *testing.T) {
+		if _, err := NewFromSnapshot(ean32, nil); err == nil {
+			t.Error("want error; got: nil")
+		}
+	})
+
+	t.Run("Unknown coefficient set name", func(t *testing.T) {
+		var s Snapshot
+		if err := json.Unmarshal([]byte(`{"coef_set":"ZH-L16D"}`), &s); err == nil {
+			t.Error("want error; got: nil")
+		}
+	})
+}
@@ -0,0 +1,311 @@
+package diveplanner
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/m5lapp/diveplanner/gasmix"
+	"github.com/m5lapp/diveplanner/helpers"
+)
+
+// Custom type to represent the purpose of a cylinder in a DivePlan.
+type CylinderRole int
+
+const (
+	BackGas CylinderRole = iota
+	StageGas
+	DecoGas
+	BailoutGas
+)
+
+func (cr CylinderRole) String() string {
+	switch cr {
+	case BackGas:
+		return "Back Gas"
+	case StageGas:
+		return "Stage"
+	case DecoGas:
+		return "Deco"
+	case BailoutGas:
+		return "Bailout"
+	}
+	return "Unknown Cylinder Role"
+}
+
+// Cylinder represents a single gas cylinder carried on a dive. Volume is the
+// cylinder's water capacity in litres and the pressures are in bar. If
+// StartPressure is zero, the cylinder is assumed to be filled to its
+// WorkingPressure. The gas mixes of any DecoGas cylinders are used as
+// decompression gases in addition to the plan's DecoGases.
+type Cylinder struct {
+	Name            string         `bson:"name" json:"name"`
+	Volume          float64        `bson:"volume" json:"volume"`
+	WorkingPressure float64        `bson:"working_pressure" json:"working_pressure"`
+	StartPressure   float64        `bson:"start_pressure" json:"start_pressure"`
+	GasMix          *gasmix.GasMix `bson:"gas_mix" json:"gas_mix"`
+	Role            CylinderRole   `bson:"role" json:"role"`
+}
+
+// startPressure() returns the pressure in bar of the cylinder at the start of
+// the dive.
+func (c *Cylinder) startPressure() float64 {
+	if c.StartPressure > 0.0 {
+		return c.StartPressure
+	}
+	return c.WorkingPressure
+}
+
+// GasAvailable() returns the amount of gas in litres at the surface in the
+// cylinder at the start of the dive. The compressibility of the cylinder's gas
+// mix is taken into account, so at high pressures this is less than Volume ×
+// pressure.
+func (c *Cylinder) GasAvailable() float64 {
+	return c.gasAt(c.startPressure())
+}
+
+// gasAt() returns the amount of gas in litres at the surface in the cylinder
+// when it is at the given pressure in bar.
+func (c *Cylinder) gasAt(pressure float64) float64 {
+	if c.GasMix == nil {
+		return c.Volume * math.Max(pressure, 0.0)
+	}
+	return c.GasMix.FreeGasVolume(c.Volume, pressure)
+}
+
+// pressureFor() returns the pressure in bar of the cylinder when it holds the
+// given amount of gas in litres at the surface. If the amount is negative, as
+// the cylinder does not hold enough gas, then so is the pressure and it is the
+// shortfall divided by the cylinder's volume.
+func (c *Cylinder) pressureFor(gas float64) float64 {
+	if c.GasMix == nil || gas < 0.0 {
+		return gas / c.Volume
+	}
+	return c.GasMix.PressureForVolume(c.Volume, gas)
+}
+
+// validate() validates the cylinder at index i of a DivePlan's cylinders and
+// appends any errors to errs.
+func (c *Cylinder) validate(i int, errs []error) []error {
+	name := fmt.Sprintf("Cylinder %d", i)
+
+	errs = numInRange(name+" Volume", c.Volume, 0.5, 30.0, errs)
+	errs = numInRange(name+" Working Pressure", c.WorkingPressure, 100.0, 350.0, errs)
+	if c.StartPressure != 0.0 {
+		errs = numInRange(name+" Start Pressure", c.StartPressure, 1.0, c.WorkingPressure, errs)
+	}
+	errs = numInRange(name+" Role", int(c.Role), int(BackGas), int(BailoutGas), errs)
+	if c.GasMix == nil {
+		errs = append(errs, fmt.Errorf("cylinder %d gas mix cannot be empty", i))
+	}
+
+	return errs
+}
+
+// CylinderGas holds the planned gas usage in litres at the surface for one of
+// the cylinders in a DivePlan. GasUsed is the gas breathed from the cylinder
+// during the dive as planned and GasRequired adds the same contingency as
+// DivePlan.GasRequired(). MinGas is the gas to keep in reserve to get to the
+// surface from the deepest point at which the cylinder is breathed.
+type CylinderGas struct {
+	Cylinder     *Cylinder `bson:"cylinder" json:"cylinder"`
+	GasAvailable float64   `bson:"gas_available" json:"gas_available"`
+	GasUsed      float64   `bson:"gas_used" json:"gas_used"`
+	GasRequired  float64   `bson:"gas_required" json:"gas_required"`
+	MinGas       float64   `bson:"min_gas" json:"min_gas"`
+	GasSpare     float64   `bson:"gas_spare" json:"gas_spare"`
+}
+
+// cylinders() returns the plan's cylinders. If the plan has none, then a single
+// back gas cylinder is returned that represents all of the plan's tanks.
+func (dp *DivePlan) cylinders() []*Cylinder {
+	if len(dp.Cylinders) > 0 {
+		return dp.Cylinders
+	}
+
+	return []*Cylinder{{
+		Volume:          float64(dp.TankCount) * dp.TankCapacity,
+		WorkingPressure: float64(dp.WorkingPressure),
+		StartPressure:   float64(dp.StartPressure),
+		GasMix:          dp.GasMix,
+		Role:            BackGas,
+	}}
+}
+
+// stopGas() returns the gas mix breathed during the given stop of the plan and
+// the transition to it.
+func (dp *DivePlan) stopGas(s *DivePlanStop) *gasmix.GasMix {
+	if s.Cylinder >= 0 && s.Cylinder < len(dp.Cylinders) {
+		return dp.Cylinders[s.Cylinder].GasMix
+	}
+	return dp.GasMix
+}
+
+// cylinderFor() returns the index of the cylinder containing the given gas mix,
+// preferring DecoGas cylinders. If none do, then current is returned.
+func (dp *DivePlan) cylinderFor(gm *gasmix.GasMix, current int) int {
+	found := -1
+	for i, c := range dp.Cylinders {
+		if c.GasMix == nil || c.GasMix.FO2 != gm.FO2 || c.GasMix.FHe != gm.FHe {
+			continue
+		}
+
+		if c.Role == DecoGas {
+			return i
+		} else if found < 0 {
+			found = i
+		}
+	}
+
+	if found < 0 {
+		return current
+	}
+	return found
+}
+
+// decoGases() returns the plan's DecoGases along with the gas mix of each of its
+// DecoGas cylinders.
+func (dp *DivePlan) decoGases() []*gasmix.GasMix {
+	gases := append([]*gasmix.GasMix(nil), dp.DecoGases...)
+	for _, c := range dp.Cylinders {
+		if c.Role == DecoGas && c.GasMix != nil {
+			gases = append(gases, c.GasMix)
+		}
+	}
+	return gases
+}
+
+// CylinderGas() returns the planned gas usage for each of the plan's cylinders
+// in the same order as Cylinders. Each segment of the dive profile uses the gas
+// in the cylinder that it is assigned to. If the plan has no cylinders, then a
+// single entry is returned for all of the plan's tanks.
+func (dp *DivePlan) CylinderGas() []*CylinderGas {
+	if len(dp.Cylinders) == 0 {
+		return []*CylinderGas{{
+			Cylinder:     dp.cylinders()[0],
+			GasAvailable: dp.GasAvailable(),
+			GasUsed:      dp.baseGasRequired(),
+			GasRequired:  dp.GasRequired(),
+			MinGas:       dp.MinGas() * float64(dp.TankCount),
+			GasSpare:     dp.GasSpare(),
+		}}
+	}
+
+	var usage []*CylinderGas
+	for _, c := range dp.Cylinders {
+		usage = append(usage, &CylinderGas{Cylinder: c, GasAvailable: c.GasAvailable()})
+	}
+
+	env := dp.Environment()
+	maxDepths := make([]float64, len(dp.Cylinders))
+	for _, s := range dp.DiveProfile() {
+		if s.Cylinder < 0 || s.Cylinder >= len(usage) {
+			continue
+		}
+
+		usage[s.Cylinder].GasUsed += s.GasRequirementAt(dp.SACRate, dp.DiveFactor, env)
+		if !s.IsTransition && s.Depth > maxDepths[s.Cylinder] {
+			maxDepths[s.Cylinder] = s.Depth
+		}
+	}
+
+	for i, u := range usage {
+		u.GasRequired = u.GasUsed * dp.GasStrategy.contingency()
+		if maxDepths[i] > 0.0 {
+			u.MinGas = dp.minGasFrom(maxDepths[i], i)
+		}
+		u.GasSpare = u.GasAvailable - u.MinGas - u.GasRequired
+	}
+
+	return usage
+}
+
+// Custom type to represent the unit that cylinder pressures are reported in.
+type PressureUnit int
+
+const (
+	Bar PressureUnit = iota
+	PSI
+)
+
+func (pu PressureUnit) String() string {
+	switch pu {
+	case Bar:
+		return "bar"
+	case PSI:
+		return "psi"
+	}
+	return "Unknown Pressure Unit"
+}
+
+// fromBar() converts a pressure in bar to the unit.
+func (pu PressureUnit) fromBar(pressure float64) float64 {
+	if pu == PSI {
+		return helpers.BarToPSI(pressure)
+	}
+	return pressure
+}
+
+// SegmentPressures holds the expected pressure in each of a plan's cylinders at
+// the end of one segment of its dive profile.
+type SegmentPressures struct {
+	Stop      *DivePlanStop `bson:"stop" json:"stop"`
+	Pressures []float64     `bson:"pressures" json:"pressures"`
+}
+
+// CylinderPressures holds the expected pressure in each of a plan's cylinders,
+// in the same order as its cylinders, at the start of the dive, after each
+// segment of its dive profile and at the end of the dive. The pressures can be
+// checked against the diver's pressure gauges during the dive.
+type CylinderPressures struct {
+	Unit     PressureUnit       `bson:"unit" json:"unit"`
+	Start    []float64          `bson:"start" json:"start"`
+	Segments []SegmentPressures `bson:"segments" json:"segments"`
+	End      []float64          `bson:"end" json:"end"`
+}
+
+// CylinderPressures() returns the expected pressure in each of the plan's
+// cylinders, or a single entry for all of its tanks if it has none, throughout
+// the dive in the given unit. The pressures are based on the gas breathed from
+// each cylinder during the dive as planned without any contingency, allowing for
+// the compressibility of its gas mix. If a cylinder does not hold enough gas,
+// then its pressure falls below zero by the shortfall in litres divided by its
+// volume, so running out of gas is not hidden. If the plan has no Cylinders,
+// then every segment is breathed from its tanks whatever its Cylinder value.
+// Otherwise, as in CylinderGas(), segments that are assigned to a cylinder that
+// the plan does not have are not included.
+func (dp *DivePlan) CylinderPressures(unit PressureUnit) *CylinderPressures {
+	env := dp.Environment()
+	cylinders := dp.cylinders()
+	gas := make([]float64, len(cylinders))
+	pressures := make([]float64, len(cylinders))
+	for i, c := range cylinders {
+		gas[i] = c.GasAvailable()
+		pressures[i] = c.startPressure()
+	}
+
+	// inUnit() returns a copy of the current pressures in the given unit.
+	inUnit := func() []float64 {
+		ps := make([]float64, len(pressures))
+		for i, p := range pressures {
+			ps[i] = unit.fromBar(p)
+		}
+		return ps
+	}
+
+	cp := &CylinderPressures{Unit: unit, Start: inUnit()}
+	for _, s := range dp.DiveProfile() {
+		i := s.Cylinder
+		if len(dp.Cylinders) == 0 {
+			i = 0
+		}
+
+		if i >= 0 && i < len(cylinders) {
+			gas[i] -= s.GasRequirementAt(dp.SACRate, dp.DiveFactor, env)
+			pressures[i] = cylinders[i].pressureFor(gas[i])
+		}
+		cp.Segments = append(cp.Segments, SegmentPressures{Stop: s, Pressures: inUnit()})
+	}
+	cp.End = inUnit()
+
+	return cp
+}
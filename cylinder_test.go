@@ -0,0 +1,282 @@
+package diveplanner
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/m5lapp/diveplanner/gasmix"
+	"github.com/m5lapp/diveplanner/helpers"
+)
+
+// newCylinderTestPlan() returns a plan for a dive to 30m on EAN32 back gas with
+// an EAN50 deco cylinder.
+func newCylinderTestPlan(duration float64) *DivePlan {
+	ean32, _ := gasmix.NewNitroxMix(0.32)
+	ean50, _ := gasmix.NewNitroxMix(0.50)
+
+	return &DivePlan{
+		Name:        "Cylinders",
+		DescentRate: 20,
+		AscentRate:  9,
+		SACRate:     15,
+		DiveFactor:  1.5,
+		MaxPPO2:     1.4,
+		GasMix:      ean32,
+		IsDecoDive:  true,
+		Cylinders: []*Cylinder{
+			{Name: "Back", Volume: 24, WorkingPressure: 232, StartPressure: 220, GasMix: ean32, Role: BackGas},
+			{Name: "Deco", Volume: 7, WorkingPressure: 200, GasMix: ean50, Role: DecoGas},
+		},
+		Stops: []*DivePlanStop{
+			{30.0, duration, false, "", 0},
+		},
+	}
+}
+
+// The free gas volumes in litres of the test plan's cylinders, derived from
+// published Z values of 1.0365 for EAN32 at 220 bar and 1.0069 for EAN50 at
+// 200 bar. Volumes derived from Z values to four decimal places are only
+// accurate to around a quarter of a litre, so they are compared to within
+// gasTolerance litres and the resulting pressures to within pressureTolerance
+// bar.
+const (
+	ean32Available    float64 = 24.0 * 220.0 / 1.0365
+	ean50Available    float64 = 7.0 * 200.0 / 1.0069
+	gasTolerance      float64 = 0.5
+	pressureTolerance float64 = 0.1
+)
+
+// withinTolerance() reports whether a and b differ by no more than tol.
+func withinTolerance(a, b, tol float64) bool {
+	return math.Abs(a-b) <= tol
+}
+
+func TestCylinderGas(t *testing.T) {
+	tests := []struct {
+		name          string
+		duration      float64
+		wantCylinders []int
+		want          [2]CylinderGas
+		wantPossible  bool
+	}{
+		{
+			name:          "20min @ 30m",
+			duration:      20,
+			wantCylinders: []int{0, 0, 0},
+			want: [2]CylinderGas{
+				{GasAvailable: ean32Available, GasUsed: 2137.5, GasRequired: 3206.25, MinGas: 1248.75, GasSpare: ean32Available - 3206.25 - 1248.75},
+				{GasAvailable: ean50Available, GasSpare: ean50Available},
+			},
+			wantPossible: true,
+		}, {
+			name:          "40min @ 30m, EAN50 deco",
+			duration:      40,
+			wantCylinders: []int{0, 0, 0, 1, 1},
+			want: [2]CylinderGas{
+				{GasAvailable: ean32Available, GasUsed: 3891.375, GasRequired: 5837.0625, MinGas: 1248.75, GasSpare: ean32Available - 5837.0625 - 1248.75},
+				{GasAvailable: ean50Available, GasUsed: 113.625, GasRequired: 170.4375, MinGas: 469.125, GasSpare: ean50Available - 170.4375 - 469.125},
+			},
+			wantPossible: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dp := newCylinderTestPlan(tt.duration)
+			if errs := dp.Validate(); len(errs) != 0 {
+				t.Fatalf("want no errors; got: %v", errs)
+			}
+
+			profile := dp.DiveProfile()
+			if len(profile) != len(tt.wantCylinders) {
+				t.Fatalf("want %d stops; got: %d", len(tt.wantCylinders), len(profile))
+			}
+			for i, s := range profile {
+				if s.Cylinder != tt.wantCylinders[i] {
+					t.Errorf("stop %d cylinder want: %d; got: %d", i, tt.wantCylinders[i], s.Cylinder)
+				}
+			}
+
+			var spare float64
+			for i, cg := range dp.CylinderGas() {
+				w := tt.want[i]
+				if cg.Cylinder != dp.Cylinders[i] ||
+					!withinTolerance(cg.GasAvailable, w.GasAvailable, gasTolerance) ||
+					!helpers.EqualFloat64(cg.GasUsed, w.GasUsed) ||
+					!helpers.EqualFloat64(cg.GasRequired, w.GasRequired) ||
+					!helpers.EqualFloat64(cg.MinGas, w.MinGas) ||
+					!withinTolerance(cg.GasSpare, w.GasSpare, gasTolerance) {
+					t.Errorf("cylinder %d want: %+v; got: %+v", i, w, *cg)
+				}
+				spare += cg.GasSpare
+			}
+
+			if !helpers.EqualFloat64(dp.GasSpare(), spare) {
+				t.Errorf("gas spare want: %f; got: %f", spare, dp.GasSpare())
+			}
+
+			if got := dp.DiveIsPossible(); got != tt.wantPossible {
+				t.Errorf("dive possible want: %v; got: %v", tt.wantPossible, got)
+			}
+		})
+	}
+}
+
+func TestStageCylinder(t *testing.T) {
+	ean28, _ := gasmix.NewNitroxMix(0.28)
+	dp := newCylinderTestPlan(20)
+	dp.IsDecoDive = false
+	dp.Cylinders = append(dp.Cylinders, &Cylinder{Volume: 15, WorkingPressure: 232, GasMix: ean28, Role: StageGas})
+	dp.Stops = []*DivePlanStop{
+		{33.0, 12, false, "", 2},
+		{15.0, 20, false, "", 0},
+	}
+
+	// The descent and the first stop are breathed from the stage and the
+	// ascent to the second stop from the back gas.
+	env := dp.Environment()
+	want := (2.0*env.Pressure(16.5) + 12.0*env.Pressure(33.0)) * dp.SACRate * dp.DiveFactor
+	if got := dp.CylinderGas()[2].GasUsed; !helpers.EqualFloat64(got, want) {
+		t.Errorf("stage gas used want: %f; got: %f", want, got)
+	}
+
+	if !dp.DiveIsPossible() {
+		t.Error("want dive to be possible")
+	}
+
+	// The EAN50 deco cylinder cannot be breathed at 33m with a max PPO2 of 1.4.
+	dp.Stops[0].Cylinder = 1
+	if dp.DiveIsPossible() {
+		t.Error("want dive to exceed the MOD")
+	}
+}
+
+func TestValidateCylinders(t *testing.T) {
+	dp := newCylinderTestPlan(20)
+	dp.Cylinders = append(dp.Cylinders,
+		&Cylinder{Volume: 40, WorkingPressure: 400, StartPressure: 300, Role: 4},
+		nil,
+	)
+	dp.Stops[0].Cylinder = 4
+
+	want := []error{
+		errors.New("Cylinder 2 Volume value (40) must be between 0.5 and 30 inclusive"),
+		errors.New("Cylinder 2 Working Pressure value (400) must be between 100 and 350 inclusive"),
+		errors.New("Cylinder 2 Role value (4) must be between 0 and 3 inclusive"),
+		errors.New("cylinder 2 gas mix cannot be empty"),
+		errors.New("cylinder 3 cannot be empty"),
+		errors.New("Stop 0 Cylinder value (4) must be between 0 and 3 inclusive"),
+	}
+
+	errs := dp.Validate()
+	if len(errs) != len(want) {
+		t.Fatalf("want %d errors; got: %d - %v", len(want), len(errs), errs)
+	}
+
+	for i, e := range errs {
+		if e.Error() != want[i].Error() {
+			t.Errorf("want: %v; got: %v", want[i], e)
+		}
+	}
+}
+
+func TestCylinderPressures(t *testing.T) {
+	t.Run("Cylinders in bar", func(t *testing.T) {
+		dp := newCylinderTestPlan(20)
+		cp := dp.CylinderPressures(Bar)
+
+		if got := fmt.Sprint(cp.Start); got != "[220 200]" {
+			t.Errorf("start want: [220 200]; got: %s", got)
+		}
+
+		if len(cp.Segments) != len(dp.DiveProfile()) {
+			t.Fatalf("segments want: %d; got: %d", len(dp.DiveProfile()), len(cp.Segments))
+		}
+
+		// All of the gas is breathed from the 24l back gas cylinder, which falls
+		// further than the 89 bar an ideal gas would as EAN32 is compressible.
+		// The remaining gas is at around 122.4 bar where EAN32 has a Z value of
+		// 0.9935.
+		want := []float64{(ean32Available - 2137.5) * 0.9935 / 24.0, 200}
+		for i := range want {
+			if !withinTolerance(cp.End[i], want[i], pressureTolerance) {
+				t.Errorf("end want: %v; got: %v", want, cp.End)
+			}
+		}
+
+		prev := cp.Start[0]
+		for i, s := range cp.Segments {
+			if s.Pressures[0] > prev {
+				t.Errorf("segment %d pressure rose from %v to %v", i, prev, s.Pressures[0])
+			}
+			prev = s.Pressures[0]
+		}
+	})
+
+	t.Run("Cylinders in psi", func(t *testing.T) {
+		dp := newCylinderTestPlan(20)
+		bar, psi := dp.CylinderPressures(Bar), dp.CylinderPressures(PSI)
+
+		for i := range bar.End {
+			if want := helpers.BarToPSI(bar.End[i]); psi.End[i] != want {
+				t.Errorf("cylinder %d want: %v; got: %v", i, want, psi.End[i])
+			}
+		}
+	})
+
+	t.Run("Tanks with a start pressure", func(t *testing.T) {
+		dp := newCylinderTestPlan(20)
+		dp.Cylinders = nil
+		dp.TankCount = 2
+		dp.TankCapacity = 12
+		dp.WorkingPressure = 232
+		dp.StartPressure = 220
+
+		// Two 12l tanks hold the same gas as the 24l back gas cylinder.
+		cp := dp.CylinderPressures(Bar)
+		if got := fmt.Sprint(cp.Start); got != "[220]" {
+			t.Errorf("start want: [220]; got: %s", got)
+		}
+
+		if want := (ean32Available - 2137.5) * 0.9935 / 24.0; len(cp.End) != 1 || !withinTolerance(cp.End[0], want, pressureTolerance) {
+			t.Errorf("end want: [%v]; got: %v", want, cp.End)
+		}
+
+		if !withinTolerance(dp.GasAvailable(), ean32Available, gasTolerance) {
+			t.Errorf("gas available want: %v; got: %v", ean32Available, dp.GasAvailable())
+		}
+
+		// Stop cylinders are ignored without Cylinders, so all of the gas
+		// still comes from the tanks.
+		dp.Stops[0].Cylinder = 1
+		if got := fmt.Sprint(dp.CylinderPressures(Bar).End); got != fmt.Sprint(cp.End) {
+			t.Errorf("with a stop cylinder want: %v; got: %s", cp.End, got)
+		}
+	})
+
+	t.Run("Pressures fall below zero when out of gas", func(t *testing.T) {
+		dp := newCylinderTestPlan(20)
+		dp.Cylinders[0].StartPressure = 50
+
+		cg := dp.CylinderGas()[0]
+		want := (cg.GasAvailable - cg.GasUsed) / dp.Cylinders[0].Volume
+		if got := dp.CylinderPressures(Bar).End[0]; want >= 0.0 || !helpers.EqualFloat64(got, want) {
+			t.Errorf("want: %v; got: %v", want, got)
+		}
+	})
+
+	t.Run("Segments on unknown cylinders are not charged", func(t *testing.T) {
+		dp := newCylinderTestPlan(20)
+		dp.Stops = append(dp.Stops, &DivePlanStop{Depth: 15.0, Duration: 10, Cylinder: 5})
+
+		cp := dp.CylinderPressures(Bar)
+		for i, cg := range dp.CylinderGas() {
+			want := cg.Cylinder.pressureFor(cg.GasAvailable - cg.GasUsed)
+			if !helpers.EqualFloat64(cp.End[i], want) {
+				t.Errorf("cylinder %d want: %v; got: %v", i, want, cp.End[i])
+			}
+		}
+	})
+}
@@ -0,0 +1,127 @@
+// Package deco defines the types shared by the decompression models, such as
+// buhlmann and vpmb, so that they can be used interchangeably.
+package deco
+
+import (
+	"math"
+	"time"
+
+	"github.com/m5lapp/diveplanner/gasmix"
+)
+
+// Stop represents a single mandatory decompression stop.
+type Stop struct {
+	// Depth of the stop in metres.
+	Depth float64 `bson:"depth" json:"depth"`
+	// Length of the stop in minutes.
+	Duration float64 `bson:"duration" json:"duration"`
+	// The breathing gas mix to use for the stop.
+	GasMix *gasmix.GasMix `bson:"gas_mix" json:"gas_mix"`
+	// The model's elapsed time in minutes at the end of the stop.
+	Runtime float64 `bson:"runtime" json:"runtime"`
+}
+
+// NDLResult represents a No Decompression Limit. If NoLimit is true, then the
+// diver could stay at the current depth for at least the maximum time that was
+// searched for, which is given in Duration, without incurring a decompression
+// obligation.
+type NDLResult struct {
+	Duration time.Duration `bson:"duration" json:"duration"`
+	NoLimit  bool          `bson:"no_limit" json:"no_limit"`
+}
+
+// Minutes() returns the NDL in minutes.
+func (r NDLResult) Minutes() float64 {
+	return r.Duration.Minutes()
+}
+
+// Model is the interface implemented by decompression models. A model tracks
+// the inert gas loading of a diver's tissues as they move through a dive and
+// can calculate the decompression obligation from its current state.
+type Model interface {
+	// TransitionCalc() loads a descent or ascent to the given depth in metres
+	// at the given rate in m/min.
+	TransitionCalc(depth, rate float64)
+	// StopCalc() loads staying at the current depth for the given time in
+	// minutes.
+	StopCalc(time float64)
+	// GasMix() returns the gas mix currently being breathed.
+	GasMix() *gasmix.GasMix
+	// SwitchGas() changes the gas mix being breathed.
+	SwitchGas(gm *gasmix.GasMix)
+	// AddDecoGas() registers a gas mix to switch to during decompression at
+	// its MOD for the given maximum PPO2 in bar.
+	AddDecoGas(gm *gasmix.GasMix, maxPPO2 float64) error
+	// ClearDecoGases() removes all of the registered decompression gases.
+	ClearDecoGases()
+	// Ceiling() returns the current ascent ceiling in metres. A value of zero
+	// or less means the diver can ascend directly to the surface.
+	Ceiling() float64
+	// NDL() returns the No Decompression Limit at the current depth, searching
+	// up to maxNDL, or a default maximum if maxNDL is zero or negative.
+	NDL(maxNDL time.Duration) NDLResult
+	// DecoStops() returns the decompression stops required to ascend to the
+	// surface from the current state at the given ascent rate in m/min. If a
+	// stop cannot be completed within MaxStopTime, then the stops up to and
+	// including that one, with its length capped at MaxStopTime, are returned
+	// along with an error.
+	DecoStops(aRate float64) ([]Stop, error)
+	// Clone() returns an independent copy of the model.
+	Clone() Model
+}
+
+const (
+	// epsilon is the tolerance used when rounding stop lengths to account for
+	// floating-point errors.
+	epsilon float64 = 1e-9
+	// MaxStopTime is the longest time in minutes that a model will stay at a
+	// single decompression stop waiting for its ascent ceiling to clear the
+	// next stop. Some settings, such as a low GF high with a shallow last stop,
+	// mean that the ceiling never clears as the tissues reach equilibrium.
+	MaxStopTime float64 = 24.0 * 60.0
+)
+
+// StopLengths() returns the length of each of the given stops in minutes,
+// rounded up to the nearest whole minute.
+func StopLengths(stops []Stop) []int {
+	var lengths []int
+
+	for _, s := range stops {
+		lengths = append(lengths, int(math.Ceil(s.Duration-epsilon)))
+	}
+
+	return lengths
+}
+
+// TimeToSurface() returns the time in minutes that it would take to ascend
+// from the given depth in metres to the surface at the given ascent rate in
+// m/min, including the time spent at any mandatory decompression stops that
+// the model requires. The depth should be the model's current depth. An error
+// is returned if the stops cannot be calculated, see Model.DecoStops().
+func TimeToSurface(m Model, depth, aRate float64) (float64, error) {
+	if depth <= 0.0 || aRate <= 0.0 {
+		return 0.0, nil
+	}
+
+	stops, err := m.DecoStops(aRate)
+	if err != nil {
+		return 0.0, err
+	}
+
+	tts := depth / aRate
+	for _, s := range stops {
+		tts += s.Duration
+	}
+
+	return tts, nil
+}
+
+// TimeToSurfaceAfter() is like TimeToSurface() but returns the time to surface
+// if the diver were to stay at the current depth for the given additional time
+// in minutes. For example, a time of five minutes gives the "@+5" value shown
+// by many dive computers. The model itself is not modified.
+func TimeToSurfaceAfter(m Model, depth, time, aRate float64) (float64, error) {
+	model := m.Clone()
+	model.StopCalc(time)
+	return TimeToSurface(model, depth, aRate)
+}
@@ -0,0 +1,49 @@
+package deco
+
+import (
+	"fmt"
+
+	"github.com/m5lapp/diveplanner/gasmix"
+	"github.com/m5lapp/diveplanner/helpers"
+)
+
+// Gas represents a decompression gas and the depth in metres at and above
+// which a model may switch to it.
+type Gas struct {
+	GasMix      *gasmix.GasMix
+	SwitchDepth float64
+}
+
+// Gases holds the gas mixes that a model can switch to during decompression
+// stops.
+type Gases []Gas
+
+// Add() registers a gas mix that can be used during decompression. The switch
+// depth is the gas mix's MOD in the given environment at the given maximum
+// PPO2, typically 1.6 bar for decompression gases.
+func (gs *Gases) Add(gm *gasmix.GasMix, maxPPO2 float64, env helpers.Environment) error {
+	if gm == nil {
+		return fmt.Errorf("deco: Deco gas mix cannot be nil")
+	}
+
+	if maxPPO2 < 0.21 || maxPPO2 > 1.6 {
+		return fmt.Errorf("deco: Invalid deco gas max PPO2 value (%f), should be between 0.21 and 1.6 inclusive", maxPPO2)
+	}
+
+	*gs = append(*gs, Gas{GasMix: gm, SwitchDepth: gm.MODAt(maxPPO2, env)})
+	return nil
+}
+
+// Best() returns the gas mix with the highest fraction of Oxygen that can be
+// breathed at the given depth in metres out of the decompression gases and the
+// given bottom gas. If no decompression gas is suitable, then the bottom gas is
+// returned.
+func (gs Gases) Best(depth float64, bottomGas *gasmix.GasMix) *gasmix.GasMix {
+	best := bottomGas
+	for _, g := range gs {
+		if g.SwitchDepth >= depth && g.GasMix.FO2 > best.FO2 {
+			best = g.GasMix
+		}
+	}
+	return best
+}
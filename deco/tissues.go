@@ -0,0 +1,65 @@
+package deco
+
+import (
+	"math"
+
+	"github.com/m5lapp/diveplanner/gasmix"
+)
+
+// InspiredPressure() returns the partial pressure in bar of an inert gas that
+// makes up the fraction fig of the breathing gas once it is inside the lungs at
+// the ambient pressure pamb in bar. The partial pressure of water vapour in the
+// lungs, pH2O in bar, reduces it from what it would otherwise be.
+func InspiredPressure(pamb, pH2O, fig float64) float64 {
+	return (pamb - pH2O) * fig
+}
+
+// SaturatedN2() returns the pressure in bar of Nitrogen in a tissue compartment
+// that is fully saturated from breathing air at the ambient pressure pamb in
+// bar, see InspiredPressure().
+func SaturatedN2(pamb, pH2O float64) float64 {
+	return InspiredPressure(pamb, pH2O, gasmix.NewAirMix().FN2)
+}
+
+// SchreinerEquation() calculates the pressure in bar of an inert gas in a
+// tissue compartment after a time t in minutes whilst the ambient pressure
+// changes at prate bar/min, starting at pamb bar, see InspiredPressure() for
+// pH2O. fig is the fraction of the inert gas in the breathing gas, pi the
+// initial pressure of the inert gas in the compartment and ht the compartment's
+// half-time in minutes for the inert gas. For a stop, prate is zero.
+func SchreinerEquation(pamb, pH2O, t, prate, fig, pi, ht float64) float64 {
+	// palv is the partial pressure of the inert gas being inspired inside the
+	// lungs (alveoli).
+	palv := InspiredPressure(pamb, pH2O, fig)
+	// k is the inert gas' half-time constant.
+	k := math.Log(2.0) / ht
+	// r is the rate of change in the inspired inert gas' pressure in bar/min.
+	r := prate * fig
+
+	return palv + r*(t-(1.0/k)) - (palv-pi-(r/k))*math.Exp(-k*t)
+}
+
+// SurfaceInterval() models the diver spending the given time in minutes at the
+// surface, or wherever the model currently is, breathing air. The model then
+// switches back to the gas mix that it was using before.
+func SurfaceInterval(m Model, time float64) {
+	diveGas := m.GasMix()
+	m.SwitchGas(gasmix.NewAirMix())
+	m.StopCalc(time)
+	m.SwitchGas(diveGas)
+}
+
+// TissuePressurer is implemented by decompression models that can report the
+// total inert gas pressure in bar in each of their tissue compartments.
+type TissuePressurer interface {
+	TissuePressures() []float64
+}
+
+// TissuePressures() returns the model's tissue pressures, or nil if the model
+// does not report them.
+func TissuePressures(m Model) []float64 {
+	if tp, ok := m.(TissuePressurer); ok {
+		return tp.TissuePressures()
+	}
+	return nil
+}
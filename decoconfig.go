@@ -0,0 +1,160 @@
+package diveplanner
+
+import (
+	"fmt"
+
+	"github.com/m5lapp/diveplanner/buhlmann"
+	"github.com/m5lapp/diveplanner/deco"
+	"github.com/m5lapp/diveplanner/gasmix"
+	"github.com/m5lapp/diveplanner/helpers"
+	"github.com/m5lapp/diveplanner/vpmb"
+)
+
+// Custom type to represent the decompression algorithm used by a DivePlan.
+type DecoAlgorithm int
+
+const (
+	Buhlmann DecoAlgorithm = iota
+	VPMB
+)
+
+func (da DecoAlgorithm) String() string {
+	switch da {
+	case Buhlmann:
+		return "Bühlmann ZH-L16"
+	case VPMB:
+		return "VPM-B"
+	}
+	return "Unknown Decompression Algorithm"
+}
+
+// DecoModelFactory is implemented by types that create the decompression
+// models a DivePlan uses to calculate tissue loading, NDLs and decompression
+// stops. DecoConfig implements it for the built-in algorithms and third parties
+// can implement it to plan dives with their own deco.Model.
+type DecoModelFactory interface {
+	// NewDecoModel() returns a new model for a diver breathing the given gas
+	// mix whose tissues are saturated at the environment's surface pressure,
+	// or an error if the model cannot be created.
+	NewDecoModel(gm *gasmix.GasMix, env helpers.Environment) (deco.Model, error)
+}
+
+// Check that DecoConfig implements the DecoModelFactory interface.
+var _ DecoModelFactory = DecoConfig{}
+
+// DecoConfig configures one of the built-in decompression models. The zero
+// value is the Bühlmann ZH-L16C algorithm without gradient factors.
+type DecoConfig struct {
+	// Decompression algorithm to use.
+	Algorithm DecoAlgorithm `bson:"algorithm" json:"algorithm"`
+	// Coefficient set used by the Bühlmann algorithm. If nil, ZH-L16C is
+	// used.
+	CoefSet *buhlmann.CoefSet `bson:"coef_set" json:"coef_set"`
+	// Custom coefficients used by the Bühlmann algorithm instead of CoefSet,
+	// e.g. loaded with buhlmann.LoadCoefTable().
+	CoefTable *buhlmann.CoefTable `bson:"coef_table" json:"coef_table"`
+	// Gradient factors as fractions of the M-value used by the Bühlmann
+	// algorithm, e.g. 0.3 and 0.85 for GF 30/85. If both are zero, then no
+	// gradient factors are applied.
+	GFLow  float64 `bson:"gf_low" json:"gf_low"`
+	GFHigh float64 `bson:"gf_high" json:"gf_high"`
+	// Distance in metres between the decompression stops calculated by the
+	// Bühlmann algorithm, e.g. buhlmann.StopIntervalFeet. If zero, three
+	// metres is used, which is also the only interval VPM-B supports.
+	StopInterval float64 `bson:"stop_interval" json:"stop_interval"`
+	// Resolution in minutes that the Bühlmann algorithm rounds the length of
+	// each decompression stop up to. If zero, one minute is used.
+	StopResolution float64 `bson:"stop_resolution" json:"stop_resolution"`
+	// Conservatism level from 0 to 4 used by the VPM-B algorithm.
+	Conservatism int `bson:"conservatism" json:"conservatism"`
+}
+
+// stopInterval() returns the distance in metres between decompression stops
+// for the configured algorithm.
+func (dc DecoConfig) stopInterval() float64 {
+	if dc.Algorithm == VPMB || dc.StopInterval == 0.0 {
+		return buhlmann.StopIntervalMetres
+	}
+	return dc.StopInterval
+}
+
+// hasGradientFactors() indicates if the configuration sets gradient factors.
+func (dc DecoConfig) hasGradientFactors() bool {
+	return dc.GFLow != 0.0 || dc.GFHigh != 0.0
+}
+
+// Validate() validates a DecoConfig struct, it will return a slice of errors
+// which will be empty if there are no errors.
+func (dc DecoConfig) Validate() []error {
+	var errs []error
+
+	errs = numInRange("Deco Algorithm", int(dc.Algorithm), int(Buhlmann), int(VPMB), errs)
+	if dc.CoefSet != nil {
+		errs = numInRange("Coefficient Set", int(*dc.CoefSet), int(buhlmann.ZHL16A), int(buhlmann.ZHL16C), errs)
+	}
+	if dc.CoefTable != nil {
+		if err := dc.CoefTable.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if dc.hasGradientFactors() {
+		errs = numInRange("GF Low", dc.GFLow, 0.01, 1.0, errs)
+		errs = numInRange("GF High", dc.GFHigh, 0.01, 1.0, errs)
+		if dc.GFLow > dc.GFHigh {
+			errs = append(errs, fmt.Errorf("GF Low value (%v) must not exceed GF High value (%v)", dc.GFLow, dc.GFHigh))
+		}
+	}
+	errs = numInRange("Conservatism", dc.Conservatism, 0, vpmb.MaxConservatism, errs)
+	if dc.StopInterval != 0.0 {
+		errs = numInRange("Stop Interval", dc.StopInterval, 1.0, 10.0, errs)
+	}
+	if dc.StopResolution != 0.0 {
+		errs = numInRange("Stop Resolution", dc.StopResolution, 1.0/60.0, 1.0, errs)
+	}
+
+	return errs
+}
+
+// configure() applies the configuration's settings to a Bühlmann model, for
+// instance, one that was rebuilt from a snapshot.
+func (dc DecoConfig) configure(bmann *buhlmann.ZhlModel) error {
+	if dc.hasGradientFactors() {
+		return bmann.SetGradientFactors(dc.GFLow, dc.GFHigh)
+	}
+	return nil
+}
+
+// NewDecoModel() returns a new model for the configured algorithm. If the
+// configuration is invalid, then the first error from Validate() is returned.
+func (dc DecoConfig) NewDecoModel(gm *gasmix.GasMix, env helpers.Environment) (deco.Model, error) {
+	if errs := dc.Validate(); len(errs) > 0 {
+		return nil, errs[0]
+	}
+
+	if dc.Algorithm == VPMB {
+		m := vpmb.NewWithEnvironment(gm, env)
+		if err := m.SetConservatism(dc.Conservatism); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+
+	ccs := buhlmann.ZHL16C
+	if dc.CoefSet != nil {
+		ccs = *dc.CoefSet
+	}
+
+	m := buhlmann.NewWithEnvironment(gm, ccs, env)
+	if dc.CoefTable != nil {
+		tm, err := buhlmann.NewWithCoefTable(gm, dc.CoefTable, env)
+		if err != nil {
+			return nil, err
+		}
+		m = tm
+	}
+
+	if err := dc.configure(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
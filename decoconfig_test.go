@@ -0,0 +1,270 @@
+package diveplanner
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/m5lapp/diveplanner/buhlmann"
+	"github.com/m5lapp/diveplanner/deco"
+	"github.com/m5lapp/diveplanner/gasmix"
+	"github.com/m5lapp/diveplanner/helpers"
+	"github.com/m5lapp/diveplanner/vpmb"
+)
+
+func TestDecoConfigWithinNDLs(t *testing.T) {
+	zhl16b := buhlmann.ZHL16B
+	lowA := buhlmann.ZHL16C.Table()
+	for i := range lowA.Compartments {
+		lowA.Compartments[i].N2A *= 0.8
+	}
+
+	tests := []struct {
+		name     string
+		config   DecoConfig
+		duration float64
+		want     bool
+	}{
+		{"ZH-L16C 45min", DecoConfig{}, 45, true},
+		{"ZH-L16B 45min", DecoConfig{CoefSet: &zhl16b}, 45, true},
+		{"ZH-L16C 80% a 45min", DecoConfig{CoefTable: lowA}, 45, false},
+		{"ZH-L16C GF 30/70 35min", DecoConfig{GFLow: 0.3, GFHigh: 0.7}, 35, false},
+		{"VPM-B 45min", DecoConfig{Algorithm: VPMB}, 45, true},
+		{"VPM-B 55min", DecoConfig{Algorithm: VPMB}, 55, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dp := &DivePlan{
+				DescentRate: 18,
+				AscentRate:  9,
+				GasMix:      gasmix.NewAirMix(),
+				DecoConfig:  tt.config,
+				Stops: []*DivePlanStop{
+					{18.0, tt.duration, false, "", 0},
+				},
+			}
+
+			if got := dp.WithinNDLs(); got != tt.want {
+				t.Errorf("want: %v; got: %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// countingFactory is a DecoModelFactory that counts the models it creates.
+type countingFactory struct {
+	count int
+}
+
+func (f *countingFactory) NewDecoModel(gm *gasmix.GasMix, env helpers.Environment) (deco.Model, error) {
+	f.count++
+	return buhlmann.NewWithEnvironment(gm, buhlmann.ZHL16A, env), nil
+}
+
+func TestDecoModelFactory(t *testing.T) {
+	factory := &countingFactory{}
+	dp := &DivePlan{
+		DescentRate:      20,
+		AscentRate:       9,
+		GasMix:           gasmix.NewAirMix(),
+		IsDecoDive:       true,
+		DecoModelFactory: factory,
+		// The factory should take precedence over the configuration.
+		DecoConfig: DecoConfig{Algorithm: VPMB},
+		Stops: []*DivePlanStop{
+			{30.0, 30, false, "", 0},
+		},
+	}
+
+	stops, err := dp.DecoSchedule(buhlmann.New(dp.GasMix, buhlmann.ZHL16A))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := deco.StopLengths(stops)
+
+	var got []int
+	for _, s := range dp.DiveProfile() {
+		if !s.IsTransition && s.Depth < 30.0 {
+			got = append(got, int(s.Duration))
+		}
+	}
+
+	if fmt.Sprint(want) != fmt.Sprint(got) {
+		t.Errorf("deco stops want: %v; got: %v", want, got)
+	}
+
+	for _, f := range []func(){
+		func() { dp.WithinNDLs() },
+		func() { dp.ChartProfile(60) },
+	} {
+		before := factory.count
+		f()
+		if factory.count == before {
+			t.Errorf("factory was not used")
+		}
+	}
+}
+
+func TestDecoConfigNewDecoModel(t *testing.T) {
+	invalidSet := buhlmann.CoefSet(7)
+
+	tests := []struct {
+		name    string
+		config  DecoConfig
+		wantErr bool
+	}{
+		{"Default", DecoConfig{}, false},
+		{"GF 30/85", DecoConfig{GFLow: 0.3, GFHigh: 0.85}, false},
+		{"GF low above GF high", DecoConfig{GFLow: 0.85, GFHigh: 0.3}, true},
+		{"Invalid coefficient set", DecoConfig{CoefSet: &invalidSet}, true},
+		{"Empty coefficient table", DecoConfig{CoefTable: &buhlmann.CoefTable{}}, true},
+		{"VPM-B conservatism 4", DecoConfig{Algorithm: VPMB, Conservatism: 4}, false},
+		{"VPM-B conservatism 5", DecoConfig{Algorithm: VPMB, Conservatism: 5}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := tt.config.NewDecoModel(gasmix.NewAirMix(), helpers.DefaultEnvironment())
+			if tt.wantErr && (err == nil || m != nil) {
+				t.Errorf("want an error and no model; got: %v, %v", m, err)
+			} else if !tt.wantErr && (err != nil || m == nil) {
+				t.Errorf("want a model and no error; got: %v, %v", m, err)
+			}
+		})
+	}
+}
+
+// failingFactory is a DecoModelFactory that cannot create any models.
+type failingFactory struct{}
+
+func (f failingFactory) NewDecoModel(gm *gasmix.GasMix, env helpers.Environment) (deco.Model, error) {
+	return nil, fmt.Errorf("no model")
+}
+
+func TestDecoModelFactoryValidate(t *testing.T) {
+	dp := newCylinderTestPlan(20)
+	dp.DecoModelFactory = failingFactory{}
+
+	if got := fmt.Sprint(dp.Validate()); got != "[no model]" {
+		t.Errorf("want: [no model]; got: %s", got)
+	}
+}
+
+func TestUnvalidatedDecoModel(t *testing.T) {
+	want := newCylinderTestPlan(40)
+	tests := []struct {
+		name   string
+		modify func(dp *DivePlan)
+	}{
+		{name: "Failing factory", modify: func(dp *DivePlan) { dp.DecoModelFactory = failingFactory{} }},
+		{name: "Invalid algorithm", modify: func(dp *DivePlan) { dp.DecoConfig = DecoConfig{Algorithm: 5} }},
+		{name: "Invalid last stop", modify: func(dp *DivePlan) { dp.LastStopDepth = 4.5 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The plan is planned with the default ZH-L16C model instead.
+			dp := newCylinderTestPlan(40)
+			tt.modify(dp)
+			if len(dp.Validate()) == 0 {
+				t.Fatal("want validation errors")
+			}
+
+			if got, w := fmt.Sprint(dp.decoStops()), fmt.Sprint(want.decoStops()); got != w {
+				t.Errorf("deco stops want: %s; got: %s", w, got)
+			}
+
+			if dp.Runtime() != want.Runtime() || dp.WithinNDLs() != want.WithinNDLs() ||
+				dp.DiveIsPossible() != want.DiveIsPossible() || dp.MinGas() != want.MinGas() ||
+				dp.CNS() != want.CNS() || dp.POT() != want.POT() {
+				t.Error("want the same results as the default model")
+			}
+
+			if got, w := fmt.Sprint(dp.ChartProfile(60)), fmt.Sprint(want.ChartProfile(60)); got != w {
+				t.Errorf("chart profile want: %s; got: %s", w, got)
+			}
+		})
+	}
+}
+
+func TestDeprecatedDecoAlgorithm(t *testing.T) {
+	dp := newCylinderTestPlan(20)
+	dp.DecoAlgorithm = VPMB
+	dp.Conservatism = 2
+
+	if m, ok := dp.decoModel().(*vpmb.Model); !ok || m.Conservatism() != 2 {
+		t.Errorf("want a VPM-B model with conservatism 2; got: %T", dp.decoModel())
+	}
+
+	// DecoConfig takes precedence once it is set.
+	dp.DecoConfig = DecoConfig{GFLow: 0.3, GFHigh: 0.85}
+	if _, ok := dp.decoModel().(*buhlmann.ZhlModel); !ok {
+		t.Errorf("want a Bühlmann model; got: %T", dp.decoModel())
+	}
+}
+
+func TestVPMBAscentRate(t *testing.T) {
+	dp := newCylinderTestPlan(20)
+	dp.DecoConfig = DecoConfig{Algorithm: VPMB}
+	dp.AscentRate = 3
+
+	// The NDLs are found for the plan's ascent rate rather than the default.
+	m, ok := dp.configuredDecoModel().(*vpmb.Model)
+	if !ok || m.AscentRate() != dp.AscentRate {
+		t.Fatalf("want a VPM-B model with an ascent rate of %v; got: %T", dp.AscentRate, dp.configuredDecoModel())
+	}
+
+	def := vpmb.New(dp.GasMix)
+	m.TransitionCalc(30.0, dp.DescentRate)
+	def.TransitionCalc(30.0, dp.DescentRate)
+	if m.NDL(0) == def.NDL(0) {
+		t.Errorf("want a different NDL at %vm/min to %vm/min; got: %v", dp.AscentRate, vpmb.NDLAscentRate, m.NDL(0))
+	}
+}
+
+func TestDecoConfigStopOptions(t *testing.T) {
+	ean32, _ := gasmix.NewNitroxMix(0.32)
+	dp := newCylinderTestPlan(60)
+	dp.Cylinders = nil
+	dp.TankCount, dp.TankCapacity, dp.WorkingPressure = 2, 12, 232
+	dp.GasMix = ean32
+	dp.LastStopDepth = 2.0 * buhlmann.StopIntervalFeet
+	dp.DecoConfig = DecoConfig{StopInterval: buhlmann.StopIntervalFeet, StopResolution: 1.0 / 6.0}
+	if errs := dp.Validate(); len(errs) != 0 {
+		t.Fatalf("want no errors; got: %v", errs)
+	}
+
+	// A single 20ft stop whose length is rounded up to ten seconds.
+	stops := dp.decoStops()
+	if len(stops) != 1 || stops[0].Depth != 6.096 {
+		t.Fatalf("want one stop at 6.096m; got: %v", stops)
+	}
+	if tenths := stops[0].Duration * 6.0; math.Abs(tenths-math.Round(tenths)) > 1e-9 {
+		t.Errorf("want a multiple of ten seconds; got: %vmin", stops[0].Duration)
+	}
+
+	dp.LastStopDepth = 6.0
+	dp.DecoConfig.StopInterval = 12.0
+	want := []string{
+		"Last Stop Depth value (6) must be 12 or 24",
+		"Stop Interval value (12) must be between 1 and 10 inclusive",
+	}
+	if got := fmt.Sprint(dp.Validate()); got != fmt.Sprint(want) {
+		t.Errorf("want: %v; got: %s", want, got)
+	}
+
+	// The invalid options are also rejected by the model itself.
+	if _, err := dp.DecoSchedule(buhlmann.New(ean32, buhlmann.ZHL16C)); err == nil {
+		t.Errorf("want an error for the invalid stop interval; got: nil")
+	}
+
+	// On air with GF 30/30, the last stop at 6m never clears.
+	dp.GasMix = gasmix.NewAirMix()
+	dp.DecoConfig = DecoConfig{GFLow: 0.3, GFHigh: 0.3}
+	errs := dp.Validate()
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "does not clear") {
+		t.Errorf("want a stop that does not clear error; got: %v", errs)
+	}
+}
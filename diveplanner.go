@@ -6,8 +6,11 @@ import (
 	"time"
 
 	"github.com/m5lapp/diveplanner/buhlmann"
+	"github.com/m5lapp/diveplanner/deco"
 	"github.com/m5lapp/diveplanner/gasmix"
 	"github.com/m5lapp/diveplanner/helpers"
+	"github.com/m5lapp/diveplanner/oxygen"
+	"github.com/m5lapp/diveplanner/vpmb"
 )
 
 const (
@@ -22,6 +25,8 @@ const (
 	OtuSingleDiveLimit     float64 = 850.0
 
 	safetyStopDepth float64 = 5.0
+	// Default maximum PPO2 in bar for decompression gases.
+	defaultDecoPPO2 float64 = 1.6
 )
 
 type DivePlanStop struct {
@@ -29,13 +34,22 @@ type DivePlanStop struct {
 	Duration     float64 `bson:"duration" json:"duration"`
 	IsTransition bool    `bson:"is_transition" json:"is_transition"`
 	Comment      string  `bson:"comment" json:"comment"`
+	// Index of the plan's cylinder that is breathed from during the stop and
+	// the transition to it. It is ignored if the plan has no Cylinders.
+	Cylinder int `bson:"cylinder" json:"cylinder"`
 }
 
 // GasRequirement() calculates the amount of breathing gas that a diver with a
 // given Surface Air Consumption (SAC) rate in litres/minute requires for a
 // given stop.
 func (s *DivePlanStop) GasRequirement(sacRate, diveFactor float64) float64 {
-	p := helpers.Pressure(s.Depth)
+	return s.GasRequirementAt(sacRate, diveFactor, helpers.DefaultEnvironment())
+}
+
+// GasRequirementAt() is like GasRequirement() but for a stop in the given
+// environment.
+func (s *DivePlanStop) GasRequirementAt(sacRate, diveFactor float64, env helpers.Environment) float64 {
+	p := env.Pressure(s.Depth)
 	return p * sacRate * diveFactor * float64(s.Duration)
 }
 
@@ -55,6 +69,55 @@ type DivePlan struct {
 	GasMix          *gasmix.GasMix  `bson:"nitrox_mix" json:"nitrox_mix"`
 	MaxPPO2         float64         `bson:"max_ppo2" json:"max_ppo2"`
 	Stops           []*DivePlanStop `bson:"stops" json:"stops"`
+	// Pressure in bar that the tanks are filled to at the start of the dive.
+	// If zero, they are assumed to be filled to their WorkingPressure.
+	StartPressure int `bson:"start_pressure" json:"start_pressure"`
+	// Altitude of the dive site in metres above sea-level. It is ignored if
+	// SurfacePressure is set.
+	Altitude float64 `bson:"altitude" json:"altitude"`
+	// Atmospheric pressure at the dive site in bar. If zero, it is calculated
+	// from the Altitude.
+	SurfacePressure float64 `bson:"surface_pressure" json:"surface_pressure"`
+	// Time in minutes spent at the dive site's altitude before the dive after
+	// arriving from sea-level. Zero means that the diver is fully acclimatised.
+	AcclimatisationTime float64 `bson:"acclimatisation_time" json:"acclimatisation_time"`
+	// Density of the water at the dive site in kg/l, e.g.
+	// helpers.SaltWaterDensity. If zero, ten metres of water per bar is used.
+	WaterDensity float64 `bson:"water_density" json:"water_density"`
+	// If true, the plan may exceed the no-decompression limits and the
+	// required decompression stops are added to the end of the dive profile.
+	IsDecoDive bool `bson:"is_deco_dive" json:"is_deco_dive"`
+	// Gas mixes that can be switched to during decompression stops.
+	DecoGases []*gasmix.GasMix `bson:"deco_gases" json:"deco_gases"`
+	// Maximum PPO2 in bar for the decompression gases which determines the
+	// depth that each one can be switched to. If zero, 1.6 is used.
+	DecoPPO2 float64 `bson:"deco_ppo2" json:"deco_ppo2"`
+	// Depth in metres of the last decompression stop, either one or two of
+	// the DecoConfig's stop intervals, e.g. 3 or 6. If zero, one stop interval
+	// is used.
+	LastStopDepth float64 `bson:"last_stop_depth" json:"last_stop_depth"`
+	// Configuration of the decompression model used to calculate the tissue
+	// loading, NDLs and decompression stops for the plan.
+	DecoConfig DecoConfig `bson:"deco_config" json:"deco_config"`
+	// Optional factory for a custom decompression model which is used instead
+	// of DecoConfig if set. It is not serialised.
+	DecoModelFactory DecoModelFactory `bson:"-" json:"-"`
+	// Deprecated: Use DecoConfig instead. If DecoConfig is the zero value,
+	// then the decompression algorithm and the VPM-B conservatism level are
+	// taken from this and Conservatism.
+	DecoAlgorithm DecoAlgorithm `bson:"deco_algorithm" json:"deco_algorithm"`
+	// Deprecated: Use DecoConfig instead, see DecoAlgorithm.
+	Conservatism int `bson:"conservatism" json:"conservatism"`
+	// Cylinders carried on the dive. If set, they are used for gas planning
+	// instead of the TankCount, TankCapacity and WorkingPressure values and
+	// each stop is breathed from the cylinder that it is assigned to.
+	Cylinders []*Cylinder `bson:"cylinders" json:"cylinders"`
+	// Gas management strategy used to calculate the gas required for the dive
+	// and the turn pressures. The zero value is the rule of thirds.
+	GasStrategy GasStrategy `bson:"gas_strategy" json:"gas_strategy"`
+	// Configuration of the minimum gas calculation. If nil,
+	// DefaultMinGasProfile is used.
+	MinGasProfile *MinGasProfile `bson:"min_gas_profile" json:"min_gas_profile"`
 }
 
 // floatInRange() will chack that a given value is between two values
@@ -82,22 +145,311 @@ func (dp *DivePlan) Validate() []error {
 	errs = numInRange("Descent Rate", dp.DescentRate, 1.0, 30.0, errs)
 	errs = numInRange("Ascent Rate", dp.AscentRate, 1.0, 18.0, errs)
 	errs = numInRange("SAC Rate", dp.SACRate, 1.0, 100.0, errs)
-	errs = numInRange("Tank Count", dp.TankCount, 1, 6, errs)
-	errs = numInRange("Tank Capacity", dp.TankCapacity, 3.0, 20.0, errs)
-	errs = numInRange("Tank Working Pressure", dp.WorkingPressure, 150, 300, errs)
+	if len(dp.Cylinders) == 0 {
+		errs = numInRange("Tank Count", dp.TankCount, 1, 6, errs)
+		errs = numInRange("Tank Capacity", dp.TankCapacity, 3.0, 20.0, errs)
+		errs = numInRange("Tank Working Pressure", dp.WorkingPressure, 150, 300, errs)
+		if dp.StartPressure != 0 {
+			errs = numInRange("Tank Start Pressure", dp.StartPressure, 1, dp.WorkingPressure, errs)
+		}
+	}
 	errs = numInRange("Dive Factor", dp.DiveFactor, 1.0, 6.0, errs)
 	errs = numInRange("Max PPO2", dp.MaxPPO2, 0.21, 1.6, errs)
+	errs = numInRange("Altitude", dp.Altitude, 0.0, 6000.0, errs)
+	errs = numInRange("Acclimatisation Time", dp.AcclimatisationTime, 0.0, 10080.0, errs)
+	if dp.SurfacePressure != 0.0 {
+		errs = numInRange("Surface Pressure", dp.SurfacePressure, 0.4, 1.1, errs)
+	}
+	if dp.WaterDensity != 0.0 {
+		errs = numInRange("Water Density", dp.WaterDensity, 0.99, 1.05, errs)
+	}
+	if dp.DecoPPO2 != 0.0 {
+		errs = numInRange("Deco PPO2", dp.DecoPPO2, 1.0, 1.6, errs)
+	}
+	if interval := dp.decoConfig().stopInterval(); dp.LastStopDepth != 0.0 &&
+		dp.LastStopDepth != interval && dp.LastStopDepth != 2.0*interval {
+		errs = append(errs, fmt.Errorf("Last Stop Depth value (%v) must be %v or %v", dp.LastStopDepth, interval, 2.0*interval))
+	}
+	errs = append(errs, dp.decoConfig().Validate()...)
+	errs = numInRange("Gas Strategy", int(dp.GasStrategy), int(RuleOfThirds), int(RockBottom), errs)
+	if dp.MinGasProfile != nil {
+		errs = append(errs, dp.MinGasProfile.Validate()...)
+	}
+	for i, gm := range dp.DecoGases {
+		if gm == nil {
+			errs = append(errs, fmt.Errorf("deco gas %d cannot be empty", i))
+		}
+	}
+
+	for i, c := range dp.Cylinders {
+		if c == nil {
+			errs = append(errs, fmt.Errorf("cylinder %d cannot be empty", i))
+			continue
+		}
+		errs = c.validate(i, errs)
+	}
 
 	for i, s := range dp.Stops {
 		depthStr := fmt.Sprintf("Stop %d Depth", i)
 		durStr := fmt.Sprintf("Stop %d Duration", i)
 		numInRange(depthStr, s.Depth, 1.0, 300.0, errs)
 		numInRange(durStr, s.Duration, 0.5, 300.0, errs)
+		if len(dp.Cylinders) > 0 {
+			cylStr := fmt.Sprintf("Stop %d Cylinder", i)
+			errs = numInRange(cylStr, s.Cylinder, 0, len(dp.Cylinders)-1, errs)
+		}
+	}
+
+	// Check that the plan's decompression model, which may be a custom one,
+	// can be created and configured for an otherwise valid plan. For a
+	// decompression dive, the stops must also be able to clear, which they may
+	// not with a low GF high and a shallow last stop.
+	if len(errs) == 0 {
+		m, err := dp.newDecoModel()
+		if err == nil {
+			err = dp.configureDeco(m)
+		}
+		if err == nil && dp.IsDecoDive {
+			_, err = dp.DecoSchedule(m)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
 	}
 
 	return errs
 }
 
+// Environment() returns the environment at the dive site based on the plan's
+// SurfacePressure or Altitude and WaterDensity values. If none are set, then
+// the default sea-level environment is returned.
+func (dp *DivePlan) Environment() helpers.Environment {
+	env := helpers.DefaultEnvironment()
+	env.WaterDensity = dp.WaterDensity
+
+	if dp.SurfacePressure > 0.0 {
+		env.SurfacePressure = dp.SurfacePressure
+	} else if dp.Altitude > 0.0 {
+		env.SurfacePressure = helpers.AltitudePressure(dp.Altitude)
+	}
+
+	return env
+}
+
+// acclimatiser is implemented by decompression models that can model a diver
+// acclimatising to the surface pressure at altitude.
+type acclimatiser interface {
+	Acclimatise(fromP, time float64)
+}
+
+// acclimatise() prepares a new decompression model for the dive. If the diver
+// is not fully acclimatised to the dive site's altitude and the model supports
+// it, then the model starts off saturated at sea-level and off-gasses at the
+// surface for the acclimatisation time.
+func (dp *DivePlan) acclimatise(m deco.Model) {
+	if a, ok := m.(acclimatiser); ok && dp.AcclimatisationTime > 0.0 {
+		a.Acclimatise(helpers.DefaultEnvironment().Pressure(0.0), dp.AcclimatisationTime)
+	}
+}
+
+// decoPPO2() returns the maximum PPO2 to use for the decompression gases.
+func (dp *DivePlan) decoPPO2() float64 {
+	if dp.DecoPPO2 > 0.0 {
+		return dp.DecoPPO2
+	}
+	return defaultDecoPPO2
+}
+
+// configureDeco() registers the plan's decompression gases, including those in
+// any DecoGas cylinders, with the given decompression model, replacing any that
+// were already registered, and sets the plan's last stop depth and ascent rate
+// along with the stop interval and resolution from its DecoConfig. An error is
+// returned if the model rejects any of these.
+func (dp *DivePlan) configureDeco(m deco.Model) error {
+	dc := dp.decoConfig()
+	switch dm := m.(type) {
+	case *buhlmann.ZhlModel:
+		err := dm.SetDecoOptions(buhlmann.DecoOptions{
+			StopInterval:   dc.StopInterval,
+			LastStop:       dp.LastStopDepth,
+			StopResolution: dc.StopResolution,
+		})
+		if err != nil {
+			return err
+		}
+	case *vpmb.Model:
+		if dp.LastStopDepth > 0.0 {
+			if err := dm.SetLastStop(dp.LastStopDepth); err != nil {
+				return err
+			}
+		}
+		if dp.AscentRate > 0.0 {
+			if err := dm.SetAscentRate(dp.AscentRate); err != nil {
+				return err
+			}
+		}
+	}
+
+	m.ClearDecoGases()
+	for _, gm := range dp.decoGases() {
+		if gm == nil {
+			continue
+		}
+
+		if err := m.AddDecoGas(gm, dp.decoPPO2()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decoConfig() returns the plan's DecoConfig, or one made from the deprecated
+// DecoAlgorithm and Conservatism fields if it is the zero value.
+func (dp *DivePlan) decoConfig() DecoConfig {
+	if dp.DecoConfig == (DecoConfig{}) {
+		return DecoConfig{Algorithm: dp.DecoAlgorithm, Conservatism: dp.Conservatism}
+	}
+	return dp.DecoConfig
+}
+
+// newDecoModel() returns a new decompression model from the plan's
+// DecoModelFactory, or its DecoConfig if that is not set, ready to start the
+// dive, or an error if the model cannot be created.
+func (dp *DivePlan) newDecoModel() (deco.Model, error) {
+	var factory DecoModelFactory = dp.decoConfig()
+	if dp.DecoModelFactory != nil {
+		factory = dp.DecoModelFactory
+	}
+
+	m, err := factory.NewDecoModel(dp.GasMix, dp.Environment())
+	if err != nil {
+		return nil, err
+	}
+
+	dp.acclimatise(m)
+	return m, nil
+}
+
+// decoModel() is like newDecoModel() for a plan that has been validated with
+// Validate(), which checks that the model can be created. If it cannot, then
+// the default Bühlmann ZH-L16C model is used instead, see fallbackDecoModel().
+func (dp *DivePlan) decoModel() deco.Model {
+	m, err := dp.newDecoModel()
+	if err != nil {
+		return dp.fallbackDecoModel()
+	}
+	return m
+}
+
+// configuredDecoModel() is like decoModel() but the model is also configured
+// with the plan's decompression gases and options, see configureDeco(). If the
+// model cannot be configured, then the default model is used instead with its
+// default options and the plan's decompression gases at the default PPO2.
+func (dp *DivePlan) configuredDecoModel() deco.Model {
+	m := dp.decoModel()
+	if err := dp.configureDeco(m); err != nil {
+		m = dp.fallbackDecoModel()
+		for _, gm := range dp.decoGases() {
+			if gm != nil {
+				// This cannot fail for a gas mix at the default PPO2.
+				_ = m.AddDecoGas(gm, defaultDecoPPO2)
+			}
+		}
+	}
+	return m
+}
+
+// fallbackDecoModel() returns a new Bühlmann ZH-L16C model, the default
+// decompression model, for the plan's environment and acclimatisation. It is
+// used in place of the plan's own model if that cannot be created or
+// configured, which Validate() reports, so that planning an invalid plan does
+// not fail.
+func (dp *DivePlan) fallbackDecoModel() deco.Model {
+	m := buhlmann.NewWithEnvironment(dp.GasMix, buhlmann.ZHL16C, dp.Environment())
+	dp.acclimatise(m)
+	return m
+}
+
+// DecoSchedule() models each of the plan's stops and the transitions to them
+// with the given decompression model, which should be new, and returns the
+// decompression stops that it requires at the end of the last stop. This can
+// be used to compare the schedules of different decompression models for the
+// same plan. An error is returned if the model cannot be configured with the
+// plan's decompression gases or if it cannot calculate the stops, see
+// deco.Model.
+func (dp *DivePlan) DecoSchedule(m deco.Model) ([]deco.Stop, error) {
+	if err := dp.configureDeco(m); err != nil {
+		return nil, err
+	}
+	dp.modelStops(m, dp.Stops)
+
+	return m.DecoStops(dp.AscentRate)
+}
+
+// modelStops() models each of the given stops and the transitions to them,
+// starting from the surface, with the given decompression model.
+func (dp *DivePlan) modelStops(m deco.Model, stops []*DivePlanStop) {
+	var prevDepth float64
+
+	for _, s := range stops {
+		if !s.IsTransition {
+			rate := dp.DescentRate
+			if helpers.DescOrAsc(prevDepth, s.Depth) == -1.0 {
+				rate = dp.AscentRate
+			}
+
+			m.SwitchGas(dp.stopGas(s))
+			m.TransitionCalc(s.Depth, rate)
+			m.StopCalc(s.Duration)
+			prevDepth = s.Depth
+		}
+	}
+}
+
+// decoStops() returns the decompression stops required at the end of the
+// plan's last stop using the plan's decompression model. It returns an
+// empty slice if the plan is not a decompression dive. Validate() checks that
+// the stops can be calculated, otherwise a stop that cannot be completed is
+// capped at deco.MaxStopTime.
+func (dp *DivePlan) decoStops() []deco.Stop {
+	if !dp.IsDecoDive {
+		return nil
+	}
+
+	m := dp.configuredDecoModel()
+	dp.modelStops(m, dp.Stops)
+
+	stops, _ := m.DecoStops(dp.AscentRate)
+	return stops
+}
+
+// ascend() models the ascent to the surface from the end of the plan's last
+// stop with the given decompression model. For decompression dives, this
+// includes each of the decompression stops and gas switches. An error is
+// returned if the model cannot be configured for or calculate the
+// decompression stops.
+func (dp *DivePlan) ascend(m deco.Model) error {
+	if dp.IsDecoDive {
+		if err := dp.configureDeco(m); err != nil {
+			return err
+		}
+
+		stops, err := m.DecoStops(dp.AscentRate)
+		if err != nil {
+			return err
+		}
+		for _, ds := range stops {
+			m.TransitionCalc(ds.Depth, dp.AscentRate)
+			m.SwitchGas(ds.GasMix)
+			m.StopCalc(ds.Duration)
+		}
+	}
+
+	m.TransitionCalc(0.0, dp.AscentRate)
+	return nil
+}
+
 // transitionDuration() calculates the amount of time in minutes required to
 // transition from one depth in metres to another at the configured ascent or
 // descent rate, rounded up to the nearest minute for conservatism. If the
@@ -122,8 +474,9 @@ func (dp *DivePlan) transitionDuration(fromD, toD float64) float64 {
 }
 
 // transitionStop() returns a DivePlanStop that represents a transition from one
-// depth to another; it's Depth value is the average of the two.
-func (dp *DivePlan) transitionStop(fromD, toD float64) *DivePlanStop {
+// depth to another whilst breathing from the given cylinder; it's Depth value is
+// the average of the two.
+func (dp *DivePlan) transitionStop(fromD, toD float64, cylinder int) *DivePlanStop {
 	dir := "Descent"
 	if toD < fromD {
 		dir = "Ascent"
@@ -134,29 +487,96 @@ func (dp *DivePlan) transitionStop(fromD, toD float64) *DivePlanStop {
 		Depth:        math.Abs((fromD + toD) / 2.0),
 		IsTransition: true,
 		Comment:      fmt.Sprintf("%s from %.1fm to %.1fm", dir, fromD, toD),
+		Cylinder:     cylinder,
 	}
 }
 
-// DiveProfile() returns a slice of DivePlanStops with all the stops in the dive
-// plan and the transition to each stop from the previous one. Finally it will
-// include the transition from the last stop back to the surface.
-func (dp *DivePlan) DiveProfile() []*DivePlanStop {
+// profileSegment represents one stage of the dive profile along with the depths
+// at its start and end and the gas mix breathed during it. For a stop, both
+// depths are the same.
+type profileSegment struct {
+	stop       *DivePlanStop
+	startDepth float64
+	endDepth   float64
+	gasMix     *gasmix.GasMix
+}
+
+// profileSegments() returns a slice of profileSegments for all the stops in the
+// dive plan and the transition to each stop from the previous one, breathing
+// from the stop's cylinder. For decompression dives, the transition to each
+// required decompression stop, on the previous gas, and the stop itself are
+// then included. Finally it will include the transition from the last stop back
+// to the surface.
+func (dp *DivePlan) profileSegments() []profileSegment {
+	return dp.profileSegmentsWith(dp.decoStops())
+}
+
+// profileSegmentsWith() is like profileSegments() but uses the given
+// decompression stops, for instance, those required by a model carrying
+// residual tissue loading from earlier dives.
+func (dp *DivePlan) profileSegmentsWith(decoStops []deco.Stop) []profileSegment {
 	var currDepth float64
-	var profile []*DivePlanStop
+	var currCyl int
+	var segments []profileSegment
+	currGas := dp.GasMix
+
+	// addStop() appends the transition from the current depth to the given
+	// stop breathing the given gas mix from the given cylinder, followed by
+	// the stop itself.
+	addStop := func(s *DivePlanStop, tranGas *gasmix.GasMix, tranCyl int, gm *gasmix.GasMix) {
+		t := dp.transitionStop(currDepth, s.Depth, tranCyl)
+		segments = append(segments,
+			profileSegment{stop: t, startDepth: currDepth, endDepth: s.Depth, gasMix: tranGas},
+			profileSegment{stop: s, startDepth: s.Depth, endDepth: s.Depth, gasMix: gm},
+		)
+		currDepth = s.Depth
+		currCyl = s.Cylinder
+		currGas = gm
+	}
 
 	for _, s := range dp.Stops {
 		// Check that the stop is a valid stop, otherwise, don't include it.
 		if s.Depth > 0.0 && s.Duration > 0.0 {
-			t := dp.transitionStop(currDepth, s.Depth)
-			profile = append(profile, t, s)
-			currDepth = s.Depth
+			gm := dp.stopGas(s)
+			addStop(s, gm, s.Cylinder, gm)
 		}
 	}
 
-	// Include the final transition back to the surface and update dp.Stops.
-	if len(profile) > 0 {
-		t := dp.transitionStop(currDepth, 0.0)
-		profile = append(profile, t)
+	if len(segments) == 0 {
+		return segments
+	}
+
+	for _, ds := range decoStops {
+		comment := "Decompression stop"
+		if ds.GasMix != currGas {
+			comment = fmt.Sprintf("Decompression stop, switch to %s", ds.GasMix)
+		}
+
+		addStop(&DivePlanStop{
+			Depth:    ds.Depth,
+			Duration: ds.Duration,
+			Comment:  comment,
+			Cylinder: dp.cylinderFor(ds.GasMix, currCyl),
+		}, currGas, currCyl, ds.GasMix)
+	}
+
+	// Include the final transition back to the surface.
+	t := dp.transitionStop(currDepth, 0.0, currCyl)
+	segments = append(segments, profileSegment{stop: t, startDepth: currDepth, endDepth: 0.0, gasMix: currGas})
+
+	return segments
+}
+
+// DiveProfile() returns a slice of DivePlanStops with all the stops in the dive
+// plan and the transition to each stop from the previous one. For
+// decompression dives, the computed decompression stops are then included.
+// Finally it will include the transition from the last stop back to the
+// surface.
+func (dp *DivePlan) DiveProfile() []*DivePlanStop {
+	var profile []*DivePlanStop
+
+	for _, seg := range dp.profileSegments() {
+		profile = append(profile, seg.stop)
 	}
 
 	return profile
@@ -205,56 +625,131 @@ func (dp *DivePlan) DSRTable() *[][3]float64 {
 
 // Pulmonary Oxygen Toxicity calculates the number of Oxygen Tolerence Units
 // (OTUs) for the dive. One OTU is equivalent to breathing 100% Oxygen at 1 bar
-// for 1 minute. The single dive limit is 850 OTU on day 1 and 300 OTU for
-// repetitive dives on day 2+.
+// for 1 minute. Exposure at a PPO2 of 0.5 bar or below produces no OTUs and the
+// OTUs for ascents and descents use the integrated form of the OTU equation.
+// The single dive limit is 850 OTU on day 1 and 300 OTU for repetitive dives on
+// day 2+, see OTUTally() for multi-day exposures.
 func (dp *DivePlan) POT() float64 {
+	return dp.segmentsOTU(dp.profileSegments())
+}
+
+// segmentsOTU() calculates the OTUs for the given profile segments, see POT().
+func (dp *DivePlan) segmentsOTU(segments []profileSegment) float64 {
 	var otu float64
+	env := dp.Environment()
 
 	// Sum the OTUs for each stage in the profile.
-	for _, s := range dp.DiveProfile() {
-		otu += dp.GasMix.PPO2(s.Depth) * s.Duration
+	for _, seg := range segments {
+		startPPO2 := seg.gasMix.PPO2At(seg.startDepth, env)
+		if seg.stop.IsTransition {
+			endPPO2 := seg.gasMix.PPO2At(seg.endDepth, env)
+			otu += oxygen.OTUTransition(startPPO2, endPPO2, seg.stop.Duration)
+		} else {
+			otu += oxygen.OTUConstant(startPPO2, seg.stop.Duration)
+		}
 	}
 
 	return otu
 }
 
+// OTUTally() calculates the total OTUs for each day's dive plans and checks the
+// cumulative exposure over consecutive days against the REPEX limits. Each
+// element of days holds the plans for one day.
+func OTUTally(days [][]*DivePlan) []oxygen.REPEXDay {
+	var dailyOTUs []float64
+
+	for _, plans := range days {
+		var otu float64
+		for _, dp := range plans {
+			otu += dp.POT()
+		}
+		dailyOTUs = append(dailyOTUs, otu)
+	}
+
+	return oxygen.CheckREPEX(dailyOTUs)
+}
+
+// CNS() calculates the Central Nervous System (CNS) Oxygen toxicity loading for
+// the dive as a percentage of the NOAA single exposure limits. The loading for
+// ascents and descents is integrated over the change in PPO2.
+func (dp *DivePlan) CNS() float64 {
+	return dp.segmentsCNS(dp.profileSegments())
+}
+
+// segmentsCNS() calculates the CNS% for the given profile segments, see CNS().
+func (dp *DivePlan) segmentsCNS(segments []profileSegment) float64 {
+	var cns float64
+	env := dp.Environment()
+
+	for _, seg := range segments {
+		startPPO2 := seg.gasMix.PPO2At(seg.startDepth, env)
+		if seg.stop.IsTransition {
+			endPPO2 := seg.gasMix.PPO2At(seg.endDepth, env)
+			cns += oxygen.CNSTransition(startPPO2, endPPO2, seg.stop.Duration)
+		} else {
+			cns += oxygen.CNSConstant(startPPO2, seg.stop.Duration)
+		}
+	}
+
+	return cns
+}
+
+// CNSLevel() indicates whether the dive's CNS loading is acceptable, exceeds
+// the 80% warning limit or exceeds the 100% maximum limit.
+func (dp *DivePlan) CNSLevel() oxygen.CNSLevel {
+	return oxygen.Level(dp.CNS())
+}
+
 // MinGas() returns the amount of gas required to get two divers (or one if
 // diving solo) to the surface in an emergency from the deepest part of the dive
 // with a safety stop. For solo dives, the minimum gas is still doubled as it is
-// required to be available from two independent gas sources.
+// required to be available from two independent gas sources. The calculation
+// is configured by the plan's MinGasProfile and explained by
+// MinGasBreakdown().
+//
+// If the plan has Cylinders, then the total minimum gas of each cylinder is
+// returned, see CylinderGas().
 func (dp *DivePlan) MinGas() float64 {
-	const buddyMultiplier float64 = 2.0
-	maxDepth := dp.MaxDepth()
-	maxPressure := helpers.Pressure(maxDepth)
-	avgPressure := helpers.Pressure(maxDepth / 2.0)
-	stopPressure := helpers.Pressure(safetyStopDepth)
-	ascentTime := dp.transitionDuration(maxDepth, 0.0)
-
-	// Account for elevated breathing rate in an emergency with a budy.
-	elevatedSACRate := dp.SACRate * dp.DiveFactor * buddyMultiplier * 1.5
-
-	// Allow one minute to sort yourself out at the maximum depth.
-	preperationGas := 1.0 * maxPressure * elevatedSACRate
-
-	// Gas required for the ascent to reach the surface with an elevated SAC
-	// rate breathing at the average depth ambient pressure.
-	ascentGas := ascentTime * avgPressure * elevatedSACRate
+	if len(dp.Cylinders) > 0 {
+		var minGas float64
+		for _, cg := range dp.CylinderGas() {
+			minGas += cg.MinGas
+		}
+		return minGas
+	}
 
-	// Include three minutes at the safety stop depth.
-	stopGas := 3.0 * stopPressure * elevatedSACRate
+	return dp.minGasFrom(dp.MaxDepth(), -1)
+}
 
-	return preperationGas + ascentGas + stopGas
+// minGasFrom() returns the minimum gas, see MinGas(), to get to the surface in
+// an emergency from the given depth in metres for the given cylinder, see
+// minGasBreakdown().
+func (dp *DivePlan) minGasFrom(maxDepth float64, cylinder int) float64 {
+	return dp.minGasBreakdown(maxDepth, cylinder).MinGas
 }
 
 // GasAvailable() returns the total amount of gas available to the diver with
-// the equipment configuration specified.
+// the equipment configuration specified, allowing for the compressibility of
+// the gas in each tank.
 func (dp *DivePlan) GasAvailable() float64 {
-	return float64(dp.TankCount) * dp.TankCapacity * float64(dp.WorkingPressure)
+	if len(dp.Cylinders) > 0 {
+		var gas float64
+		for _, c := range dp.Cylinders {
+			gas += c.GasAvailable()
+		}
+		return gas
+	}
+
+	return dp.cylinders()[0].GasAvailable()
 }
 
 // WorkingGas() is the gas available across all tanks once the minimum gas has
 // been accounted for.
 func (dp *DivePlan) WorkingGas() float64 {
+	if len(dp.Cylinders) > 0 {
+		return dp.GasAvailable() - dp.MinGas()
+	}
+
 	return dp.GasAvailable() - (dp.MinGas() * float64(dp.TankCount))
 }
 
@@ -263,25 +758,30 @@ func (dp *DivePlan) WorkingGas() float64 {
 // contingency and so should not be used without using additonal gas planning.
 func (dp *DivePlan) baseGasRequired() float64 {
 	var gasRequired float64
+	env := dp.Environment()
 
 	// Calculate the gas required for each stage in the profle with the given
 	// SAC rate and dive factor.
 	for _, s := range dp.DiveProfile() {
-		gasRequired += s.GasRequirement(dp.SACRate, dp.DiveFactor)
+		gasRequired += s.GasRequirementAt(dp.SACRate, dp.DiveFactor, env)
 	}
 
 	return gasRequired
 }
 
-// GasRequired() applies the rule of thirds to calculate the amount of gas
-// required for the dive as configured; one-third out, one-third back and
-// one-third in reserve.
+// GasRequired() applies the plan's GasStrategy to calculate the amount of gas
+// required for the dive as configured. For the rule of thirds, this is
+// one-third out, one-third back and one-third in reserve, so 1.5 times the gas
+// for the dive as planned. The rule of sixths gives three times the gas and the
+// rule of half and rock bottom strategies give the gas for the dive as planned,
+// with MinGas() kept in reserve for all of them.
 func (dp *DivePlan) GasRequired() float64 {
-	return dp.baseGasRequired() * 1.5
+	return dp.baseGasRequired() * dp.GasStrategy.contingency()
 }
 
 // GasSpare() calculates how much gas will be remaining across all tanks at the
-// end of the planned dive.
+// end of the planned dive. See CylinderGas() for the gas remaining in each of
+// the plan's cylinders.
 func (dp *DivePlan) GasSpare() float64 {
 	return dp.WorkingGas() - dp.GasRequired()
 }
@@ -306,8 +806,26 @@ func (dp *DivePlan) IsSawToothProfile() bool {
 // WithinNDLs() returns true if the dive stays with No-Decompression Limits.
 // That is, no mandatory decompression stops are required.
 func (dp *DivePlan) WithinNDLs() bool {
-	var bmann *buhlmann.ZhlModel = buhlmann.New(dp.GasMix, buhlmann.ZHL16C)
+	return dp.simulate(dp.configuredDecoModel()) > 0
+}
+
+// maxProfileNDL is the longest NDL in minutes that is reported for points in
+// the dive, longer NDLs should be read as 60+ minutes.
+const maxProfileNDL int = 60
+
+// ndl() returns the model's NDL in whole minutes, rounded down, up to a maximum
+// of maxProfileNDL.
+func ndl(m deco.Model) int {
+	return int(m.NDL(time.Duration(maxProfileNDL) * time.Minute).Minutes())
+}
+
+// simulate() models each of the plan's stops and the transitions to them with
+// the given decompression model and returns the lowest NDL found at the start
+// and end of each stop. A value of zero means that the dive requires mandatory
+// decompression stops. The final ascent to the surface is not modelled.
+func (dp *DivePlan) simulate(m deco.Model) int {
 	var prevDepth float64
+	minNDL := ndl(m)
 
 	for _, s := range dp.Stops {
 		if !s.IsTransition {
@@ -316,77 +834,145 @@ func (dp *DivePlan) WithinNDLs() bool {
 				rate = dp.AscentRate
 			}
 
-			// Simulate the transition to the stop depth and check our NDLs.
-			bmann.TransitionCalc(s.Depth, rate)
-			if bmann.GetNDL() <= 0 {
-				return false
+			// Simulate the transition to the stop depth on the stop's gas
+			// and check our NDLs.
+			m.SwitchGas(dp.stopGas(s))
+			m.TransitionCalc(s.Depth, rate)
+			if n := ndl(m); n < minNDL {
+				minNDL = n
 			}
 
 			// Simulate the stop, then check our NDLs at the end of it.
-			bmann.StopCalc(s.Duration)
-			if bmann.GetNDL() <= 0 {
-				return false
+			m.StopCalc(s.Duration)
+			if n := ndl(m); n < minNDL {
+				minNDL = n
 			}
 
 			prevDepth = s.Depth
 		}
 	}
 
+	return minNDL
+}
+
+// withinMOD() indicates if each of the plan's stops is within the MOD of the
+// gas mix that is breathed during it for the plan's MaxPPO2.
+func (dp *DivePlan) withinMOD() bool {
+	env := dp.Environment()
+	for _, s := range dp.Stops {
+		if s.Depth > dp.stopGas(s).MODAt(dp.MaxPPO2, env) {
+			return false
+		}
+	}
+	return true
+}
+
+// sufficientGas() indicates if there is enough gas in each of the plan's
+// cylinders, or its tanks if it has none, for the dive.
+func (dp *DivePlan) sufficientGas() bool {
+	for _, cg := range dp.CylinderGas() {
+		if cg.GasSpare < 0.0 {
+			return false
+		}
+	}
 	return true
 }
 
 // DiveIsPossible() returns a boolean value that indicates whether or not the
 // dive plan, is possible as it is currently configured, taking various factors
-// into account.
+// into account. Only decompression dives may exceed the no-decompression
+// limits. If the plan has Cylinders, then each of them must have enough gas
+// and each stop must be within the MOD of its cylinder's gas mix.
 func (dp *DivePlan) DiveIsPossible() bool {
 	isSawTooth := dp.IsSawToothProfile()
-	sufficientGas := dp.GasSpare() >= 0.0
-	withinMOD := dp.MaxDepth() <= dp.GasMix.MOD(dp.MaxPPO2)
-	withinNDLs := dp.WithinNDLs()
-	return !isSawTooth && sufficientGas && withinMOD && withinNDLs
+	sufficientGas := dp.sufficientGas()
+	withinMOD := dp.withinMOD()
+	withinNDLs := dp.IsDecoDive || dp.WithinNDLs()
+	withinCNS := dp.CNSLevel() != oxygen.CNSExceeded
+	return !isSawTooth && sufficientGas && withinMOD && withinNDLs && withinCNS
 }
 
+// ProfileSample represents the state of the diver at one point in a dive. TTS
+// is the time in minutes to surface from that point, including any
+// decompression stops, and AtPlus5 is the TTS if the diver were to stay at the
+// same depth for another five minutes. Both are -1 if the decompression stops
+// cannot be calculated.
 type ProfileSample struct {
-	Time  int
-	Depth float64
-	NDL   int
+	Time    int
+	Depth   float64
+	NDL     int
+	TTS     int
+	AtPlus5 int
+}
+
+// profileSample() returns a ProfileSample for the given time and depth based
+// on the current state of the decompression model. If the decompression stops
+// cannot be calculated, then the TTS and @+5 are reported as -1.
+func (dp *DivePlan) profileSample(time int, depth float64, m deco.Model) ProfileSample {
+	tts, err := deco.TimeToSurface(m, depth, dp.AscentRate)
+	if err != nil {
+		tts = -1.0
+	}
+
+	atPlus5, err := deco.TimeToSurfaceAfter(m, depth, 5.0, dp.AscentRate)
+	if err != nil {
+		atPlus5 = -1.0
+	}
+
+	return ProfileSample{
+		Time:    time,
+		Depth:   depth,
+		NDL:     ndl(m),
+		TTS:     int(math.Ceil(tts)),
+		AtPlus5: int(math.Ceil(atPlus5)),
+	}
 }
 
 // ChartProfile() returns a slice of ProfileSamples that contains the time in
-// seconds, depth and NDLs at each step of the dive in increments of the
-// resolution parameter provided, in seconds.
+// seconds, depth, NDLs, TTS and @+5 at each step of the dive in increments of
+// the resolution parameter provided, in seconds. For decompression dives, this
+// includes the decompression stops. The plan's decompression model is used
+// throughout.
 func (dp *DivePlan) ChartProfile(resolution int) []ProfileSample {
 	var profile []ProfileSample
-	var bmann *buhlmann.ZhlModel = buhlmann.New(dp.GasMix, buhlmann.ZHL16B)
+	m := dp.configuredDecoModel()
 	var currDepth float64
 	var currTime int
-	profile = append(profile, ProfileSample{currTime, currDepth, bmann.GetNDL()})
+	profile = append(profile, dp.profileSample(currTime, currDepth, m))
+
+	for _, seg := range dp.profileSegments() {
+		if seg.stop.IsTransition {
+			// The transition is walked with the stop that follows it, but
+			// using the transition's gas.
+			m.SwitchGas(seg.gasMix)
+			continue
+		}
 
-	for _, s := range dp.Stops {
-		currTime, currDepth = dp.walkTransition(currDepth, s.Depth, currTime, resolution, bmann, &profile)
+		s := seg.stop
+		currTime, currDepth = dp.walkTransition(currDepth, s.Depth, currTime, resolution, m, &profile)
+		m.SwitchGas(seg.gasMix)
 		samples := (float64(s.Duration) * 60.0) / float64(resolution)
 		for i := 0; i < int(math.Floor(samples)); i++ {
 			// Reasign currDepth to the Stop depth to account for any
 			// floating-point errors.
 			currDepth = s.Depth
 			currTime += resolution
-			bmann.StopCalc(float64(resolution) / 60.0)
-			ndl := bmann.GetNDL()
-			profile = append(profile, ProfileSample{currTime, currDepth, ndl})
+			m.StopCalc(float64(resolution) / 60.0)
+			profile = append(profile, dp.profileSample(currTime, currDepth, m))
 		}
 	}
 
 	// Final transition back to the surface.
-	_, _ = dp.walkTransition(currDepth, 0.0, currTime, resolution, bmann, &profile)
+	_, _ = dp.walkTransition(currDepth, 0.0, currTime, resolution, m, &profile)
 	return profile
 }
 
-// walkTransition() calculates the depth and NDLs at each step of res seconds
+// walkTransition() calculates the depth, NDLs and TTS at each step of res seconds
 // through a transition from one depth to another and appends them to the
 // profile slice provided. At the end, it returns the final depth and time so
 // that the calling function knows where the dive profile is up to.
 func (dp *DivePlan) walkTransition(currDepth, targetDepth float64,
-	currTime, res int, bmann *buhlmann.ZhlModel,
+	currTime, res int, m deco.Model,
 	profile *[]ProfileSample) (int, float64) {
 	// The distance in metres between the current depth and the next one. A
 	// positive value means descending, negative is ascending.
@@ -411,9 +997,8 @@ func (dp *DivePlan) walkTransition(currDepth, targetDepth float64,
 	for i := 0; i < int(math.Floor(samples)); i++ {
 		currDepth += sampleDelta
 		currTime += res
-		bmann.TransitionCalc(currDepth, rate)
-		ndl := bmann.GetNDL()
-		*profile = append(*profile, ProfileSample{currTime, currDepth, ndl})
+		m.TransitionCalc(currDepth, rate)
+		*profile = append(*profile, dp.profileSample(currTime, currDepth, m))
 	}
 
 	return currTime, currDepth
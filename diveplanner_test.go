@@ -2,7 +2,15 @@ package diveplanner
 
 import (
 	"errors"
+	"fmt"
+	"math"
 	"testing"
+
+	"github.com/m5lapp/diveplanner/buhlmann"
+	"github.com/m5lapp/diveplanner/deco"
+	"github.com/m5lapp/diveplanner/gasmix"
+	"github.com/m5lapp/diveplanner/helpers"
+	"github.com/m5lapp/diveplanner/oxygen"
 )
 
 func TestValidate(t *testing.T) {
@@ -22,9 +30,17 @@ func TestValidate(t *testing.T) {
 				WorkingPressure: 350.0,
 				DiveFactor:      0.7,
 				MaxPPO2:         2.0,
+				LastStopDepth:   4.5,
+				DecoConfig: DecoConfig{
+					Algorithm:    2,
+					CoefTable:    &buhlmann.CoefTable{},
+					GFLow:        0.9,
+					GFHigh:       0.5,
+					Conservatism: 5,
+				},
 				Stops: []*DivePlanStop{
-					{22.0, 26, false, ""},
-					{5.0, 3, false, ""},
+					{22.0, 26, false, "", 0},
+					{5.0, 3, false, "", 0},
 				},
 			},
 			want: []error{
@@ -37,6 +53,11 @@ func TestValidate(t *testing.T) {
 				errors.New("Tank Working Pressure value (350) must be between 150 and 300 inclusive"),
 				errors.New("Dive Factor value (0.7) must be between 1 and 6 inclusive"),
 				errors.New("Max PPO2 value (2) must be between 0.21 and 1.6 inclusive"),
+				errors.New("Last Stop Depth value (4.5) must be 3 or 6"),
+				errors.New("Deco Algorithm value (2) must be between 0 and 1 inclusive"),
+				errors.New("buhlmann: Invalid number of compartment coefficients (0), should be 16"),
+				errors.New("GF Low value (0.9) must not exceed GF High value (0.5)"),
+				errors.New("Conservatism value (5) must be between 0 and 4 inclusive"),
 			},
 		},
 	}
@@ -135,16 +156,16 @@ func TestDiveProfile(t *testing.T) {
 				DescentRate: 20,
 				AscentRate:  9,
 				Stops: []*DivePlanStop{
-					{22.0, 26, false, ""},
-					{5.0, 3, false, ""},
+					{22.0, 26, false, "", 0},
+					{5.0, 3, false, "", 0},
 				},
 			},
 			want: []*DivePlanStop{
-				{11.0, 2, true, "Descent from 0.0m to 22.0m"},
-				{22.0, 26, false, ""},
-				{13.5, 2, true, "Ascent from 22.0m to 5.0m"},
-				{5.0, 3, false, ""},
-				{2.5, 1, true, "Ascent from 5.0m to 0.0m"},
+				{11.0, 2, true, "Descent from 0.0m to 22.0m", 0},
+				{22.0, 26, false, "", 0},
+				{13.5, 2, true, "Ascent from 22.0m to 5.0m", 0},
+				{5.0, 3, false, "", 0},
+				{2.5, 1, true, "Ascent from 5.0m to 0.0m", 0},
 			},
 		},
 	}
@@ -183,10 +204,10 @@ func TestRuntime(t *testing.T) {
 				DescentRate: 20,
 				AscentRate:  9,
 				Stops: []*DivePlanStop{
-					{25.0, 13, false, ""},
-					{18.0, 15, false, ""},
-					{12.0, 23, false, ""},
-					{5.0, 3, false, ""},
+					{25.0, 13, false, "", 0},
+					{18.0, 15, false, "", 0},
+					{12.0, 23, false, "", 0},
+					{5.0, 3, false, "", 0},
 				},
 			},
 			want: 2 + 13 + 1 + 15 + 1 + 23 + 1 + 3 + 1,
@@ -196,7 +217,7 @@ func TestRuntime(t *testing.T) {
 				DescentRate: 18,
 				AscentRate:  6,
 				Stops: []*DivePlanStop{
-					{40.0, 1, false, ""},
+					{40.0, 1, false, "", 0},
 				},
 			},
 			want: 3 + 1 + 7,
@@ -233,10 +254,10 @@ func TestDSRTable(t *testing.T) {
 				DescentRate: 20,
 				AscentRate:  9,
 				Stops: []*DivePlanStop{
-					{25.0, 13, false, ""},
-					{18.0, 15, false, ""},
-					{12.0, 23, false, ""},
-					{5.0, 3, false, ""},
+					{25.0, 13, false, "", 0},
+					{18.0, 15, false, "", 0},
+					{12.0, 23, false, "", 0},
+					{5.0, 3, false, "", 0},
 				},
 			},
 			want: [][3]float64{
@@ -250,8 +271,8 @@ func TestDSRTable(t *testing.T) {
 				DescentRate: 18,
 				AscentRate:  6,
 				Stops: []*DivePlanStop{
-					{40.0, 1, false, ""},
-					{5.0, 3, false, ""},
+					{40.0, 1, false, "", 0},
+					{5.0, 3, false, "", 0},
 				},
 			},
 			want: [][3]float64{{40.0, 1.0, 4.0}, {5.0, 3.0, 13.0}},
@@ -270,3 +291,337 @@ func TestDSRTable(t *testing.T) {
 		})
 	}
 }
+
+func TestEnvironment(t *testing.T) {
+	tests := []struct {
+		name string
+		dp   *DivePlan
+		want float64
+	}{
+		{name: "Sea-level", dp: &DivePlan{}, want: 1.0},
+		{name: "Surface pressure", dp: &DivePlan{SurfacePressure: 0.85}, want: 0.85},
+		{name: "Altitude", dp: &DivePlan{Altitude: 1000.0}, want: 0.887},
+		{name: "Surface pressure overrides altitude", dp: &DivePlan{Altitude: 1000.0, SurfacePressure: 0.95}, want: 0.95},
+		{name: "Salt water", dp: &DivePlan{WaterDensity: 1.025}, want: 1.0},
+		{name: "Fresh water at altitude", dp: &DivePlan{Altitude: 1000.0, WaterDensity: 1.0}, want: 0.887},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := tt.dp.Environment()
+			if env.WaterDensity != tt.dp.WaterDensity {
+				t.Errorf("water density want: %f; got: %f", tt.dp.WaterDensity, env.WaterDensity)
+			}
+
+			p := math.Round(env.Pressure(0.0)*1000.0) / 1000.0
+			if p != tt.want {
+				t.Errorf("want: %f; got: %f", tt.want, p)
+			}
+		})
+	}
+}
+
+func TestCNS(t *testing.T) {
+	ean32, _ := gasmix.NewNitroxMix(0.32)
+	ean40, _ := gasmix.NewNitroxMix(0.40)
+
+	tests := []struct {
+		name      string
+		dp        *DivePlan
+		want      float64
+		wantLevel oxygen.CNSLevel
+	}{
+		{
+			name: "EAN32 20min @ 30m",
+			dp: &DivePlan{
+				DescentRate: 20,
+				AscentRate:  10,
+				GasMix:      ean32,
+				Stops: []*DivePlanStop{
+					{30.0, 20, false, "", 0},
+					{5.0, 3, false, "", 0},
+				},
+			},
+			want:      12.08,
+			wantLevel: oxygen.CNSOk,
+		}, {
+			name: "EAN40 130min @ 25m",
+			dp: &DivePlan{
+				DescentRate: 20,
+				AscentRate:  10,
+				GasMix:      ean40,
+				Stops: []*DivePlanStop{
+					{25.0, 130, false, "", 0},
+				},
+			},
+			want:      88.16,
+			wantLevel: oxygen.CNSWarning,
+		}, {
+			name: "EAN40 160min @ 25m",
+			dp: &DivePlan{
+				DescentRate: 20,
+				AscentRate:  10,
+				GasMix:      ean40,
+				Stops: []*DivePlanStop{
+					{25.0, 160, false, "", 0},
+				},
+			},
+			want:      108.16,
+			wantLevel: oxygen.CNSExceeded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cns := math.Round(tt.dp.CNS()*100.0) / 100.0
+			if cns != tt.want {
+				t.Errorf("want: %f; got: %f", tt.want, cns)
+			}
+
+			if l := tt.dp.CNSLevel(); l != tt.wantLevel {
+				t.Errorf("level want: %v; got: %v", tt.wantLevel, l)
+			}
+		})
+	}
+}
+
+func TestPOT(t *testing.T) {
+	ean32, _ := gasmix.NewNitroxMix(0.32)
+	o2, _ := gasmix.NewNitroxMix(1.0)
+
+	nitroxDive := &DivePlan{
+		DescentRate: 20,
+		AscentRate:  10,
+		GasMix:      ean32,
+		Stops: []*DivePlanStop{
+			{30.0, 20, false, "", 0},
+			{5.0, 3, false, "", 0},
+		},
+	}
+	oxygenDive := &DivePlan{
+		DescentRate: 20,
+		AscentRate:  10,
+		GasMix:      o2,
+		Stops: []*DivePlanStop{
+			{6.0, 60, false, "", 0},
+		},
+	}
+
+	if otu := math.Round(nitroxDive.POT()*100.0) / 100.0; otu != 32.52 {
+		t.Errorf("nitrox dive want: %f; got: %f", 32.52, otu)
+	}
+
+	if otu := math.Round(oxygenDive.POT()*100.0) / 100.0; otu != 118.39 {
+		t.Errorf("oxygen dive want: %f; got: %f", 118.39, otu)
+	}
+
+	tally := OTUTally([][]*DivePlan{
+		{nitroxDive, oxygenDive},
+		{oxygenDive, oxygenDive, oxygenDive},
+	})
+	if len(tally) != 2 {
+		t.Fatalf("want 2 days; got: %d", len(tally))
+	}
+
+	want := nitroxDive.POT() + 4*oxygenDive.POT()
+	if !helpers.EqualFloat64(tally[1].CumulativeOTU, want) || !tally[1].WithinLimits {
+		t.Errorf("day 2 want: %f, true; got: %f, %v", want, tally[1].CumulativeOTU, tally[1].WithinLimits)
+	}
+}
+
+func TestDecoDiveProfile(t *testing.T) {
+	ean32, _ := gasmix.NewNitroxMix(0.32)
+	ean50, _ := gasmix.NewNitroxMix(0.50)
+
+	tests := []struct {
+		name        string
+		dp          *DivePlan
+		want        []*DivePlanStop
+		wantRuntime float64
+	}{
+		{
+			name: "EAN32 60min @ 30m",
+			dp: &DivePlan{
+				DescentRate: 20,
+				AscentRate:  9,
+				GasMix:      ean32,
+				IsDecoDive:  true,
+				Stops: []*DivePlanStop{
+					{30.0, 60, false, "", 0},
+				},
+			},
+			want: []*DivePlanStop{
+				{15.0, 2, true, "Descent from 0.0m to 30.0m", 0},
+				{30.0, 60, false, "", 0},
+				{18.0, 3, true, "Ascent from 30.0m to 6.0m", 0},
+				{6.0, 1, false, "Decompression stop", 0},
+				{4.5, 1, true, "Ascent from 6.0m to 3.0m", 0},
+				{3.0, 14, false, "Decompression stop", 0},
+				{1.5, 1, true, "Ascent from 3.0m to 0.0m", 0},
+			},
+			wantRuntime: 82,
+		}, {
+			name: "EAN32 60min @ 30m, EAN50 deco",
+			dp: &DivePlan{
+				DescentRate: 20,
+				AscentRate:  9,
+				GasMix:      ean32,
+				IsDecoDive:  true,
+				DecoGases:   []*gasmix.GasMix{ean50},
+				Stops: []*DivePlanStop{
+					{30.0, 60, false, "", 0},
+				},
+			},
+			want: []*DivePlanStop{
+				{15.0, 2, true, "Descent from 0.0m to 30.0m", 0},
+				{30.0, 60, false, "", 0},
+				{18.0, 3, true, "Ascent from 30.0m to 6.0m", 0},
+				{6.0, 1, false, "Decompression stop, switch to EAN50", 0},
+				{4.5, 1, true, "Ascent from 6.0m to 3.0m", 0},
+				{3.0, 11, false, "Decompression stop", 0},
+				{1.5, 1, true, "Ascent from 3.0m to 0.0m", 0},
+			},
+			wantRuntime: 79,
+		}, {
+			name: "EAN32 60min @ 30m, last stop at 6m",
+			dp: &DivePlan{
+				DescentRate:   20,
+				AscentRate:    9,
+				GasMix:        ean32,
+				IsDecoDive:    true,
+				LastStopDepth: 6.0,
+				Stops: []*DivePlanStop{
+					{30.0, 60, false, "", 0},
+				},
+			},
+			want: []*DivePlanStop{
+				{15.0, 2, true, "Descent from 0.0m to 30.0m", 0},
+				{30.0, 60, false, "", 0},
+				{18.0, 3, true, "Ascent from 30.0m to 6.0m", 0},
+				{6.0, 19, false, "Decompression stop", 0},
+				{3.0, 1, true, "Ascent from 6.0m to 0.0m", 0},
+			},
+			wantRuntime: 85,
+		}, {
+			name: "EAN32 60min @ 30m, GF 30/85",
+			dp: &DivePlan{
+				DescentRate: 20,
+				AscentRate:  9,
+				GasMix:      ean32,
+				IsDecoDive:  true,
+				DecoConfig:  DecoConfig{GFLow: 0.3, GFHigh: 0.85},
+				Stops: []*DivePlanStop{
+					{30.0, 60, false, "", 0},
+				},
+			},
+			want: []*DivePlanStop{
+				{15.0, 2, true, "Descent from 0.0m to 30.0m", 0},
+				{30.0, 60, false, "", 0},
+				{19.5, 3, true, "Ascent from 30.0m to 9.0m", 0},
+				{9.0, 4, false, "Decompression stop", 0},
+				{7.5, 1, true, "Ascent from 9.0m to 6.0m", 0},
+				{6.0, 7, false, "Decompression stop", 0},
+				{4.5, 1, true, "Ascent from 6.0m to 3.0m", 0},
+				{3.0, 15, false, "Decompression stop", 0},
+				{1.5, 1, true, "Ascent from 3.0m to 0.0m", 0},
+			},
+			wantRuntime: 94,
+		}, {
+			name: "EAN32 60min @ 30m, VPM-B",
+			dp: &DivePlan{
+				DescentRate: 20,
+				AscentRate:  9,
+				GasMix:      ean32,
+				IsDecoDive:  true,
+				DecoConfig:  DecoConfig{Algorithm: VPMB},
+				Stops: []*DivePlanStop{
+					{30.0, 60, false, "", 0},
+				},
+			},
+			want: []*DivePlanStop{
+				{15.0, 2, true, "Descent from 0.0m to 30.0m", 0},
+				{30.0, 60, false, "", 0},
+				{19.5, 3, true, "Ascent from 30.0m to 9.0m", 0},
+				{9.0, 2, false, "Decompression stop", 0},
+				{7.5, 1, true, "Ascent from 9.0m to 6.0m", 0},
+				{6.0, 7, false, "Decompression stop", 0},
+				{4.5, 1, true, "Ascent from 6.0m to 3.0m", 0},
+				{3.0, 15, false, "Decompression stop", 0},
+				{1.5, 1, true, "Ascent from 3.0m to 0.0m", 0},
+			},
+			wantRuntime: 92,
+		}, {
+			name: "Not a deco dive",
+			dp: &DivePlan{
+				DescentRate: 20,
+				AscentRate:  9,
+				GasMix:      ean32,
+				Stops: []*DivePlanStop{
+					{30.0, 60, false, "", 0},
+				},
+			},
+			want: []*DivePlanStop{
+				{15.0, 2, true, "Descent from 0.0m to 30.0m", 0},
+				{30.0, 60, false, "", 0},
+				{15.0, 4, true, "Ascent from 30.0m to 0.0m", 0},
+			},
+			wantRuntime: 66,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile := tt.dp.DiveProfile()
+			if len(profile) != len(tt.want) {
+				t.Fatalf("want %d stops; got: %d", len(tt.want), len(profile))
+			}
+
+			for i, s := range profile {
+				if *s != *tt.want[i] {
+					t.Errorf("stop %d want: %v; got: %v", i, *tt.want[i], *s)
+				}
+			}
+
+			if rt := tt.dp.Runtime(); rt != tt.wantRuntime {
+				t.Errorf("runtime want: %f; got: %f", tt.wantRuntime, rt)
+			}
+		})
+	}
+}
+
+func TestDecoSchedule(t *testing.T) {
+	ean32, _ := gasmix.NewNitroxMix(0.32)
+	ean50, _ := gasmix.NewNitroxMix(0.50)
+	dp := &DivePlan{
+		DescentRate: 20,
+		AscentRate:  9,
+		GasMix:      ean32,
+		IsDecoDive:  true,
+		DecoGases:   []*gasmix.GasMix{ean50},
+		Stops: []*DivePlanStop{
+			{30.0, 60, false, "", 0},
+		},
+	}
+
+	stops, err := dp.DecoSchedule(buhlmann.New(ean32, buhlmann.ZHL16C))
+	if err != nil || fmt.Sprint(deco.StopLengths(stops)) != "[1 11]" {
+		t.Errorf("want: [1 11], nil; got: %v, %v", deco.StopLengths(stops), err)
+	}
+
+	// The model rejects a deco gas PPO2 above 1.6.
+	dp.DecoPPO2 = 1.7
+	if stops, err := dp.DecoSchedule(buhlmann.New(ean32, buhlmann.ZHL16C)); err == nil || stops != nil {
+		t.Errorf("want an error; got: %v, %v", stops, err)
+	}
+
+	// With GF 30/30 and a last stop at 6m on air, the last stop never
+	// clears.
+	dp.GasMix, dp.DecoPPO2, dp.DecoGases, dp.LastStopDepth = gasmix.NewAirMix(), 0.0, nil, 6.0
+	m := buhlmann.New(dp.GasMix, buhlmann.ZHL16C)
+	if err := m.SetGradientFactors(0.3, 0.3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dp.DecoSchedule(m); err == nil {
+		t.Error("want an error for a stop that does not clear")
+	}
+}
@@ -0,0 +1,249 @@
+package diveplanner
+
+import (
+	"fmt"
+
+	"github.com/m5lapp/diveplanner/buhlmann"
+	"github.com/m5lapp/diveplanner/deco"
+	"github.com/m5lapp/diveplanner/oxygen"
+)
+
+// SeriesDive is a single dive within a DiveSeries along with the surface
+// interval in minutes that precedes it. The surface interval of the first dive
+// in a series is ignored unless the series has an InitialState.
+type SeriesDive struct {
+	SurfaceInterval float64   `bson:"surface_interval" json:"surface_interval"`
+	Plan            *DivePlan `bson:"plan" json:"plan"`
+}
+
+// DiveSeries represents a number of repetitive dives, for instance, all the
+// dives on one day. The inert gas loading of the diver's tissues is carried
+// over from one dive to the next, off-gassing on air at the surface during each
+// surface interval. The decompression model, environment and acclimatisation
+// of the first dive's plan are used for the whole series.
+//
+// InitialState optionally holds the diver's tissue state from earlier dives,
+// such as the SurfacingState of a previous series, in which case the
+// acclimatisation of the first dive is ignored and its surface interval is
+// modelled before it starts. It can only be used when the first dive's plan
+// uses the Bühlmann algorithm.
+type DiveSeries struct {
+	Name         string             `bson:"name" json:"name"`
+	Dives        []*SeriesDive      `bson:"dives" json:"dives"`
+	InitialState *buhlmann.Snapshot `bson:"initial_state" json:"initial_state"`
+}
+
+// SeriesDiveResult holds the results of modelling one dive in a DiveSeries.
+type SeriesDiveResult struct {
+	// The lowest NDL in minutes reached during the dive.
+	MinNDL int `bson:"min_ndl" json:"min_ndl"`
+	// Indicates if the dive stayed within no-decompression limits.
+	WithinNDLs bool `bson:"within_ndls" json:"within_ndls"`
+	// The length in minutes of each mandatory decompression stop required
+	// at the end of the dive's last stop.
+	DecoStops []int `bson:"deco_stops" json:"deco_stops"`
+	// The inert gas pressure in bar in each tissue compartment at the start
+	// of the dive, left over from any previous dives.
+	ResidualLoading []float64 `bson:"residual_loading" json:"residual_loading"`
+	// The inert gas pressure in bar in each tissue compartment on surfacing.
+	SurfacingLoading []float64 `bson:"surfacing_loading" json:"surfacing_loading"`
+	// The full tissue state on surfacing which can be used to resume planning
+	// later. It is only set when the Bühlmann algorithm is used.
+	SurfacingState *buhlmann.Snapshot `bson:"surfacing_state" json:"surfacing_state"`
+	// The CNS% on surfacing including any residual CNS% from previous dives
+	// that has not yet decayed. Like OTU, the dive's own exposure includes
+	// the decompression stops required with the residual tissue loading.
+	CNS float64 `bson:"cns" json:"cns"`
+	// The severity of the CNS% on surfacing.
+	CNSLevel oxygen.CNSLevel `bson:"cns_level" json:"cns_level"`
+	// The OTUs for the dive.
+	OTU float64 `bson:"otu" json:"otu"`
+}
+
+// Validate() validates a DiveSeries struct and each of its dive plans, it
+// will return a slice of errors which will be empty if there are no errors.
+func (ds *DiveSeries) Validate() []error {
+	var errs []error
+
+	if len(ds.Dives) == 0 {
+		errs = append(errs, fmt.Errorf("dive series must contain at least one dive"))
+	}
+
+	if ds.InitialState != nil {
+		if err := ds.InitialState.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+
+		if len(ds.Dives) > 0 && ds.Dives[0].Plan != nil {
+			first := ds.Dives[0].Plan
+			if first.DecoModelFactory != nil || first.decoConfig().Algorithm != Buhlmann {
+				errs = append(errs, fmt.Errorf("initial state can only be used with the %s algorithm", Buhlmann))
+			}
+		}
+	}
+
+	for i, d := range ds.Dives {
+		if d.Plan == nil {
+			errs = append(errs, fmt.Errorf("dive %d plan cannot be empty", i))
+			continue
+		}
+
+		if i > 0 || ds.InitialState != nil {
+			siStr := fmt.Sprintf("Dive %d Surface Interval", i)
+			errs = numInRange(siStr, d.SurfaceInterval, 0.0, 2880.0, errs)
+		}
+
+		for _, e := range d.Plan.Validate() {
+			errs = append(errs, fmt.Errorf("dive %d: %w", i, e))
+		}
+	}
+
+	return errs
+}
+
+// Results() models each of the dives in the series in turn, carrying over the
+// tissue loading and CNS% between them, and returns the results for each dive.
+// The series should be validated first with Validate(), an error is returned
+// if the decompression model cannot be created or configured for the dives.
+func (ds *DiveSeries) Results() ([]*SeriesDiveResult, error) {
+	var results []*SeriesDiveResult
+	var m deco.Model
+	var cns float64
+
+	for i, d := range ds.Dives {
+		if i == 0 && ds.InitialState != nil {
+			bmann, err := buhlmann.NewFromSnapshot(d.Plan.GasMix, ds.InitialState)
+			if err != nil {
+				return nil, err
+			}
+			if err := d.Plan.decoConfig().configure(bmann); err != nil {
+				return nil, fmt.Errorf("dive %d: %w", i, err)
+			}
+			m = bmann
+			deco.SurfaceInterval(m, d.SurfaceInterval)
+		} else if i == 0 {
+			var err error
+			if m, err = d.Plan.newDecoModel(); err != nil {
+				return nil, fmt.Errorf("dive %d: %w", i, err)
+			}
+		} else {
+			deco.SurfaceInterval(m, d.SurfaceInterval)
+			m.SwitchGas(d.Plan.GasMix)
+			cns = oxygen.CNSDecay(cns, d.SurfaceInterval)
+		}
+
+		res := &SeriesDiveResult{ResidualLoading: deco.TissuePressures(m)}
+
+		// The model is configured before the dive is simulated so that the
+		// NDLs are found for the plan's own ascent rate.
+		if err := d.Plan.configureDeco(m); err != nil {
+			return nil, fmt.Errorf("dive %d: %w", i, err)
+		}
+		res.MinNDL = d.Plan.simulate(m)
+		res.WithinNDLs = res.MinNDL > 0
+		stops, err := m.DecoStops(d.Plan.AscentRate)
+		if err != nil {
+			return nil, fmt.Errorf("dive %d: %w", i, err)
+		}
+		res.DecoStops = deco.StopLengths(stops)
+
+		// The oxygen exposure is for the profile that the carried over
+		// tissue loading requires, including any longer decompression stops.
+		var profileStops []deco.Stop
+		if d.Plan.IsDecoDive {
+			profileStops = stops
+		}
+		segments := d.Plan.profileSegmentsWith(profileStops)
+
+		// Finally, ascend to the surface ready for the next dive.
+		if err := d.Plan.ascend(m); err != nil {
+			return nil, fmt.Errorf("dive %d: %w", i, err)
+		}
+		res.SurfacingLoading = deco.TissuePressures(m)
+		if bmann, ok := m.(*buhlmann.ZhlModel); ok {
+			res.SurfacingState = bmann.Snapshot()
+		}
+
+		cns += d.Plan.segmentsCNS(segments)
+		res.CNS = cns
+		res.CNSLevel = oxygen.Level(cns)
+		res.OTU = d.Plan.segmentsOTU(segments)
+
+		results = append(results, res)
+	}
+
+	return results, nil
+}
+
+// maxSurfaceInterval is the longest surface interval in minutes that
+// MinSurfaceInterval() will consider.
+const maxSurfaceInterval float64 = 24.0 * 60.0
+
+// MinSurfaceInterval() finds the shortest surface interval in whole minutes
+// after the first dive plan for which the second dive plan stays within the
+// no-decompression limits. If maxDeco is greater than zero, then the second
+// dive may instead require up to that many minutes of total decompression stop
+// time. The first dive plan's decompression model is used for both dives. An
+// error is returned if the second dive is not possible within the limits after
+// a surface interval of 24 hours or if the decompression model cannot be
+// created or configured for the dives.
+func MinSurfaceInterval(first, second *DivePlan, maxDeco float64) (float64, error) {
+	model, err := first.newDecoModel()
+	if err != nil {
+		return 0.0, err
+	}
+
+	first.simulate(model)
+	if err := first.ascend(model); err != nil {
+		return 0.0, err
+	}
+
+	// Each surface interval that is tried starts from a copy of the model,
+	// so it only needs configuring for the second dive once.
+	if err := second.configureDeco(model); err != nil {
+		return 0.0, err
+	}
+
+	// withinLimits() indicates if the second dive is within the limits when it
+	// starts from the surfaced model m.
+	withinLimits := func(m deco.Model) bool {
+		m = m.Clone()
+		m.SwitchGas(second.GasMix)
+
+		if second.simulate(m) > 0 {
+			return true
+		} else if maxDeco <= 0.0 {
+			return false
+		}
+
+		// A dive whose stops cannot be completed is never within the limits.
+		stops, err := m.DecoStops(second.AscentRate)
+		if err != nil {
+			return false
+		}
+
+		var decoTime int
+		for _, stop := range deco.StopLengths(stops) {
+			decoTime += stop
+		}
+		return float64(decoTime) <= maxDeco
+	}
+
+	// The tissue loading does not always fall during the surface interval.
+	// After breathing a gas with less Nitrogen than air, such as Heliox or
+	// Oxygen during decompression, the tissues on-gas Nitrogen at the surface,
+	// so the second dive's limits need not improve as the surface interval
+	// gets longer. Each whole minute is therefore tried in turn, advancing
+	// one surface model a minute at a time.
+	for si := 0.0; si <= maxSurfaceInterval; si++ {
+		if si > 0.0 {
+			deco.SurfaceInterval(model, 1.0)
+		}
+
+		if withinLimits(model) {
+			return si, nil
+		}
+	}
+
+	return 0.0, fmt.Errorf("second dive exceeds the limits even after a surface interval of %v minutes", maxSurfaceInterval)
+}
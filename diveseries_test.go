@@ -0,0 +1,287 @@
+package diveplanner
+
+import (
+	"testing"
+
+	"github.com/m5lapp/diveplanner/buhlmann"
+	"github.com/m5lapp/diveplanner/deco"
+	"github.com/m5lapp/diveplanner/gasmix"
+	"github.com/m5lapp/diveplanner/helpers"
+	"github.com/m5lapp/diveplanner/oxygen"
+)
+
+// newSeriesTestPlan() returns a simple 24m EAN32 dive plan for use in the dive
+// series tests.
+func newSeriesTestPlan() *DivePlan {
+	ean32, _ := gasmix.NewNitroxMix(0.32)
+	return &DivePlan{
+		Name:        "Repetitive",
+		DescentRate: 18,
+		AscentRate:  9,
+		GasMix:      ean32,
+		Stops: []*DivePlanStop{
+			{24.0, 30, false, "", 0},
+			{5.0, 3, false, "", 0},
+		},
+	}
+}
+
+func TestDiveSeriesResults(t *testing.T) {
+	tests := []struct {
+		name        string
+		si          float64
+		wantMinNDLs []int
+	}{
+		{name: "30min surface intervals", si: 30.0, wantMinNDLs: []int{19, 13, 8}},
+		{name: "60min surface intervals", si: 60.0, wantMinNDLs: []int{19, 17, 17}},
+		{name: "180min surface intervals", si: 180.0, wantMinNDLs: []int{19, 19, 19}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := &DiveSeries{
+				Name: tt.name,
+				Dives: []*SeriesDive{
+					{Plan: newSeriesTestPlan()},
+					{SurfaceInterval: tt.si, Plan: newSeriesTestPlan()},
+					{SurfaceInterval: tt.si, Plan: newSeriesTestPlan()},
+				},
+			}
+
+			results, err := ds.Results()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != len(tt.wantMinNDLs) {
+				t.Fatalf("want %d results; got: %d", len(tt.wantMinNDLs), len(results))
+			}
+
+			for i, r := range results {
+				if r.MinNDL != tt.wantMinNDLs[i] {
+					t.Errorf("dive %d min NDL want: %d; got: %d", i, tt.wantMinNDLs[i], r.MinNDL)
+				}
+
+				if !r.WithinNDLs || len(r.DecoStops) != 0 {
+					t.Errorf("dive %d want no deco; got: %v, %v", i, r.WithinNDLs, r.DecoStops)
+				}
+
+				// The slowest compartment should hold more residual gas for
+				// each successive dive.
+				if i > 0 {
+					prev := results[i-1].ResidualLoading[15]
+					if r.ResidualLoading[15] <= prev {
+						t.Errorf("dive %d residual loading want > %f; got: %f", i, prev, r.ResidualLoading[15])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDiveSeriesValidate(t *testing.T) {
+	ds := &DiveSeries{}
+	if errs := ds.Validate(); len(errs) != 1 {
+		t.Errorf("empty series want 1 error; got: %v", errs)
+	}
+
+	p := newSeriesTestPlan()
+	p.SACRate, p.TankCount, p.TankCapacity, p.WorkingPressure = 15.0, 1, 12.0, 200
+	p.DiveFactor, p.MaxPPO2 = 1.5, 1.4
+	ds = &DiveSeries{
+		Dives: []*SeriesDive{
+			{Plan: p},
+			{SurfaceInterval: -10.0, Plan: p},
+			{SurfaceInterval: 60.0},
+		},
+	}
+
+	want := []string{
+		"Dive 1 Surface Interval value (-10) must be between 0 and 2880 inclusive",
+		"dive 2 plan cannot be empty",
+	}
+	errs := ds.Validate()
+	if len(errs) != len(want) {
+		t.Fatalf("want %d errors; got: %v", len(want), errs)
+	}
+
+	for i, e := range errs {
+		if e.Error() != want[i] {
+			t.Errorf("want: %s; got: %s", want[i], e.Error())
+		}
+	}
+}
+
+func TestDiveSeriesOxygenExposure(t *testing.T) {
+	ds := &DiveSeries{
+		Dives: []*SeriesDive{
+			{Plan: newCylinderTestPlan(40)},
+			{SurfaceInterval: 60.0, Plan: newCylinderTestPlan(40)},
+		},
+	}
+
+	results, err := ds.Results()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first dive has no residual loading, so it matches the plan alone.
+	plan := ds.Dives[0].Plan
+	if !helpers.EqualFloat64(results[0].CNS, plan.CNS()) || !helpers.EqualFloat64(results[0].OTU, plan.POT()) {
+		t.Errorf("first dive want: %f, %f; got: %f, %f", plan.CNS(), plan.POT(), results[0].CNS, results[0].OTU)
+	}
+
+	// The second dive's residual loading lengthens its deco stops on EAN50,
+	// so its exposure is greater than that of the plan alone.
+	if sum(results[1].DecoStops) <= sum(deco.StopLengths(plan.decoStops())) {
+		t.Fatalf("want longer deco stops than %v; got: %v", deco.StopLengths(plan.decoStops()), results[1].DecoStops)
+	}
+
+	if results[1].OTU <= plan.POT() {
+		t.Errorf("OTU want more than: %f; got: %f", plan.POT(), results[1].OTU)
+	}
+
+	decayed := oxygen.CNSDecay(results[0].CNS, 60.0)
+	if results[1].CNS-decayed <= plan.CNS() {
+		t.Errorf("CNS want more than: %f; got: %f", plan.CNS(), results[1].CNS-decayed)
+	}
+}
+
+// sum() returns the total of the given stop lengths.
+func sum(lengths []int) int {
+	var total int
+	for _, l := range lengths {
+		total += l
+	}
+	return total
+}
+
+func TestMinSurfaceInterval(t *testing.T) {
+	tests := []struct {
+		name    string
+		depth   float64
+		time    float64
+		maxDeco float64
+		want    float64
+		wantErr bool
+	}{
+		{name: "30min @ 24m", depth: 24.0, time: 30.0, want: 5.0},
+		{name: "40min @ 24m", depth: 24.0, time: 40.0, want: 23.0},
+		{name: "25min @ 30m", depth: 30.0, time: 25.0, want: 61.0},
+		{name: "25min @ 30m, 15min deco", depth: 30.0, time: 25.0, maxDeco: 15.0, want: 0.0},
+		{name: "60min @ 30m", depth: 30.0, time: 60.0, wantErr: true},
+		{name: "60min @ 30m, 15min deco", depth: 30.0, time: 60.0, maxDeco: 15.0, want: 97.0},
+		{name: "30min @ 40m, 15min deco", depth: 40.0, time: 30.0, maxDeco: 15.0, want: 26.0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			second := newSeriesTestPlan()
+			second.Stops[0].Depth, second.Stops[0].Duration = tt.depth, tt.time
+
+			si, err := MinSurfaceInterval(newSeriesTestPlan(), second, tt.maxDeco)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("want error: %v; got: %v", tt.wantErr, err)
+			}
+
+			if si != tt.want {
+				t.Errorf("want: %f; got: %f", tt.want, si)
+			}
+		})
+	}
+}
+
+func TestMinSurfaceIntervalAfterHeliox(t *testing.T) {
+	// After a Heliox dive, the tissues hold no Nitrogen. Helium leaves them
+	// during the first few minutes at the surface, but they slowly on-gas
+	// Nitrogen from the air, so the second dive is only possible within a
+	// short window of surface intervals.
+	heliox, _ := gasmix.NewHelioxMix(0.21)
+	first := newSeriesTestPlan()
+	first.GasMix, first.IsDecoDive = heliox, true
+	first.Stops = []*DivePlanStop{{45.0, 25, false, "", 0}}
+
+	second := newSeriesTestPlan()
+	second.Stops = []*DivePlanStop{{35.0, 18, false, "", 0}}
+
+	si, err := MinSurfaceInterval(first, second, 0.0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if si != 1.0 {
+		t.Errorf("want: %f; got: %f", 1.0, si)
+	}
+
+	// Check that the window closes again, so the dive is not within the
+	// limits after a longer surface interval.
+	ds := &DiveSeries{
+		Dives: []*SeriesDive{
+			{Plan: first},
+			{SurfaceInterval: 60.0, Plan: second},
+		},
+	}
+	results, err := ds.Results()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if results[1].WithinNDLs {
+		t.Error("want second dive to exceed the NDLs after a 60min surface interval")
+	}
+}
+
+func TestDiveSeriesInitialState(t *testing.T) {
+	first := &DiveSeries{Dives: []*SeriesDive{{Plan: newSeriesTestPlan()}}}
+	firstResults, err := first.Results()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := firstResults[0].SurfacingState
+
+	p := newSeriesTestPlan()
+	p.SACRate, p.TankCount, p.TankCapacity, p.WorkingPressure = 15.0, 1, 12.0, 200
+	p.DiveFactor, p.MaxPPO2 = 1.5, 1.4
+	ds := &DiveSeries{
+		InitialState: state,
+		Dives: []*SeriesDive{
+			{SurfaceInterval: 30.0, Plan: p},
+			{SurfaceInterval: 30.0, Plan: p},
+		},
+	}
+	if errs := ds.Validate(); len(errs) != 0 {
+		t.Fatalf("want no errors; got: %v", errs)
+	}
+
+	// Resuming from the first dive's surfacing state should give the same
+	// results as planning all three dives in one series.
+	want := []int{13, 8}
+	results, err := ds.Results()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range results {
+		if r.MinNDL != want[i] {
+			t.Errorf("dive %d min NDL want: %d; got: %d", i, want[i], r.MinNDL)
+		}
+	}
+
+	p.DecoAlgorithm = VPMB
+	if errs := ds.Validate(); len(errs) != 1 {
+		t.Errorf("initial state with VPM-B want 1 error; got: %v", errs)
+	}
+	p.DecoAlgorithm = Buhlmann
+
+	ds.InitialState = &buhlmann.Snapshot{}
+	if errs := ds.Validate(); len(errs) != 1 {
+		t.Errorf("invalid initial state want 1 error; got: %v", errs)
+	}
+	if results, err := ds.Results(); err == nil || results != nil {
+		t.Errorf("invalid initial state want an error; got: %v, %v", results, err)
+	}
+
+	ds.InitialState = state
+	ds.Dives[0].Plan.DecoConfig.Algorithm = VPMB
+	if errs := ds.Validate(); len(errs) != 1 {
+		t.Errorf("initial state with VPM-B want 1 error; got: %v", errs)
+	}
+}
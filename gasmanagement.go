@@ -0,0 +1,85 @@
+package diveplanner
+
+// Custom type to represent the gas management strategy used to plan how much
+// of the gas in each cylinder can be used on a dive.
+type GasStrategy int
+
+const (
+	// One-third of the gas out, one-third back and one-third in reserve.
+	RuleOfThirds GasStrategy = iota
+	// Half of the gas out and half back.
+	RuleOfHalf
+	// One-sixth of the gas out, one-sixth back and the rest in reserve, as
+	// used for cave and other overhead environments.
+	RuleOfSixths
+	// The rock bottom, or minimum, gas is kept in reserve and the usable gas
+	// above it is split equally between the way out and the way back.
+	RockBottom
+)
+
+func (gs GasStrategy) String() string {
+	switch gs {
+	case RuleOfThirds:
+		return "Rule of Thirds"
+	case RuleOfHalf:
+		return "Rule of Half"
+	case RuleOfSixths:
+		return "Rule of Sixths"
+	case RockBottom:
+		return "Rock Bottom"
+	}
+	return "Unknown Gas Strategy"
+}
+
+// contingency() returns the multiplier applied to the gas required for the
+// dive as planned to give the total gas required under the strategy.
+func (gs GasStrategy) contingency() float64 {
+	switch gs {
+	case RuleOfHalf, RockBottom:
+		return 1.0
+	case RuleOfSixths:
+		return 3.0
+	}
+	return 1.5
+}
+
+// turnFraction() returns the fraction of a cylinder's gas that can be used on
+// the way out before turning the dive. For RockBottom, the fraction applies to
+// the usable gas above the rock bottom gas.
+func (gs GasStrategy) turnFraction() float64 {
+	switch gs {
+	case RuleOfHalf, RockBottom:
+		return 1.0 / 2.0
+	case RuleOfSixths:
+		return 1.0 / 6.0
+	}
+	return 1.0 / 3.0
+}
+
+// TurnPressures() returns the pressure in each of the plan's cylinders, or a
+// single entry for all of its tanks if it has none, at which the diver should
+// turn an out-and-back dive in the given unit. The turn pressures are based on
+// the plan's GasStrategy and each cylinder's pressure at the start of the dive.
+// For the RockBottom strategy, the rock bottom gas of each cylinder is its
+// minimum gas, see CylinderGas().
+func (dp *DivePlan) TurnPressures(unit PressureUnit) []float64 {
+	fraction := dp.GasStrategy.turnFraction()
+
+	var pressures []float64
+	for _, cg := range dp.CylinderGas() {
+		usable := cg.GasAvailable
+		if dp.GasStrategy == RockBottom {
+			usable -= cg.MinGas
+		}
+
+		turnGas := cg.GasAvailable - fraction*usable
+		if usable <= 0.0 {
+			// There is no usable gas, so the dive should be turned at once.
+			turnGas = cg.GasAvailable
+		}
+
+		pressures = append(pressures, unit.fromBar(cg.Cylinder.pressureFor(turnGas)))
+	}
+
+	return pressures
+}
@@ -0,0 +1,82 @@
+package diveplanner
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/m5lapp/diveplanner/helpers"
+)
+
+func TestGasStrategy(t *testing.T) {
+	tests := []struct {
+		strategy     GasStrategy
+		str          string
+		wantRequired float64
+		wantTurn     [2]float64
+		wantPossible bool
+	}{
+		{RuleOfThirds, "Rule of Thirds", 3206.25, [2]float64{141.34, 130.27}, true},
+		{RuleOfHalf, "Rule of Half", 2137.5, [2]float64{105.06, 97.33}, true},
+		{RuleOfSixths, "Rule of Sixths", 6412.5, [2]float64{179.39, 164.31}, false},
+		{RockBottom, "Rock Bottom", 2137.5, [2]float64{131.60, 97.33}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.str, func(t *testing.T) {
+			if tt.strategy.String() != tt.str {
+				t.Errorf("want string %s; got %s", tt.str, tt.strategy.String())
+			}
+
+			dp := newCylinderTestPlan(20)
+			dp.GasStrategy = tt.strategy
+
+			if !helpers.EqualFloat64(dp.GasRequired(), tt.wantRequired) {
+				t.Errorf("gas required want: %v; got: %v", tt.wantRequired, dp.GasRequired())
+			}
+
+			if got := dp.CylinderGas()[0].GasRequired; !helpers.EqualFloat64(got, tt.wantRequired) {
+				t.Errorf("cylinder gas required want: %v; got: %v", tt.wantRequired, got)
+			}
+
+			turn := dp.TurnPressures(Bar)
+			for i := range turn {
+				turn[i] = math.Round(turn[i]*100) / 100
+			}
+			if fmt.Sprint(turn) != fmt.Sprint(tt.wantTurn[:]) {
+				t.Errorf("turn pressures want: %v; got: %v", tt.wantTurn, turn)
+			}
+
+			if got := dp.DiveIsPossible(); got != tt.wantPossible {
+				t.Errorf("dive possible want: %v; got: %v", tt.wantPossible, got)
+			}
+		})
+	}
+
+	t.Run("Tanks in psi", func(t *testing.T) {
+		dp := newCylinderTestPlan(20)
+		dp.Cylinders = nil
+		dp.TankCount = 1
+		dp.TankCapacity = 12
+		dp.WorkingPressure = 232
+
+		bar, psi := dp.TurnPressures(Bar), dp.TurnPressures(PSI)
+		if len(bar) != 1 || math.Round(bar[0]*100)/100 != 148.31 {
+			t.Errorf("want: [148.31]; got: %v", bar)
+		}
+		if len(psi) != 1 || psi[0] != helpers.BarToPSI(bar[0]) {
+			t.Errorf("want: [%v]; got: %v", helpers.BarToPSI(bar[0]), psi)
+		}
+	})
+
+	t.Run("Invalid strategy", func(t *testing.T) {
+		dp := newCylinderTestPlan(20)
+		dp.GasStrategy = RockBottom + 1
+
+		want := "Gas Strategy value (4) must be between 0 and 3 inclusive"
+		errs := dp.Validate()
+		if len(errs) != 1 || errs[0].Error() != want {
+			t.Errorf("want: [%s]; got: %v", want, errs)
+		}
+	})
+}
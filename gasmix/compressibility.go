@@ -0,0 +1,66 @@
+package gasmix
+
+import "math"
+
+// Virial coefficients for the compressibility factor of each gas at 20°C, as a
+// polynomial in the pressure in bar. These are the fits used by Subsurface and
+// are accurate to within about 0.1% up to 500 bar.
+var (
+	o2Coefficients = [3]float64{-7.18092073703e-04, +2.81852572808e-06, -1.50290620492e-09}
+	n2Coefficients = [3]float64{-2.19260353292e-04, +2.92844845532e-06, -2.07613482075e-09}
+	heCoefficients = [3]float64{+4.87320026468e-04, -8.83632921053e-08, +5.33304543646e-11}
+)
+
+// virial() returns the deviation of a gas's compressibility factor from one
+// at the given pressure in bar.
+func virial(coefs [3]float64, pressure float64) float64 {
+	p := pressure
+	return coefs[0]*p + coefs[1]*p*p + coefs[2]*p*p*p
+}
+
+// Compressibility() returns the compressibility factor, Z, of the gas mix at
+// the given pressure in bar and a temperature of 20°C. Z is one for an ideal
+// gas. At cylinder pressures it is more than one for Oxygen, Nitrogen and
+// Helium, so a cylinder holds less gas than its capacity × pressure suggests.
+func (gm *GasMix) Compressibility(pressure float64) float64 {
+	p := math.Max(pressure, 0.0)
+
+	return 1.0 +
+		gm.FO2*virial(o2Coefficients, p) +
+		gm.FN2*virial(n2Coefficients, p) +
+		gm.FHe*virial(heCoefficients, p)
+}
+
+// FreeGasVolume() returns the volume in litres at the surface of the gas mix in
+// a cylinder with the given capacity in litres when it is filled to the given
+// pressure in bar, taking the compressibility of the gas into account.
+func (gm *GasMix) FreeGasVolume(capacity, pressure float64) float64 {
+	if pressure <= 0.0 {
+		return 0.0
+	}
+	return capacity * pressure / gm.Compressibility(pressure)
+}
+
+// PressureForVolume() is the inverse of FreeGasVolume(), it returns the
+// pressure in bar that a cylinder with the given capacity in litres must be
+// filled to with the gas mix to hold the given volume of gas in litres at the
+// surface.
+func (gm *GasMix) PressureForVolume(capacity, volume float64) float64 {
+	if capacity <= 0.0 || volume <= 0.0 {
+		return 0.0
+	}
+
+	// Z changes slowly with pressure, so a fixed-point iteration starting from
+	// the ideal gas pressure converges quickly.
+	ideal := volume / capacity
+	pressure := ideal
+	for i := 0; i < 100; i++ {
+		next := ideal * gm.Compressibility(pressure)
+		if math.Abs(next-pressure) < 1e-9 {
+			return next
+		}
+		pressure = next
+	}
+
+	return pressure
+}
@@ -0,0 +1,43 @@
+package gasmix
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCompressibility(t *testing.T) {
+	tests := []struct {
+		name     string
+		gm       *GasMix
+		pressure float64
+		wantZ    float64
+		wantVol  float64
+	}{
+		{"Air @ 1 bar", NewAirMix(), 1.0, 0.9997, 12.0},
+		{"Air @ 232 bar", NewAirMix(), 232.0, 1.0568, 2634.4},
+		{"Air @ 300 bar", NewAirMix(), 300.0, 1.1115, 3238.9},
+		{"EAN32 @ 232 bar", &GasMix{FN2: 0.68, FO2: 0.32}, 232.0, 1.0442, 2666.2},
+		{"Tx 18/45 @ 232 bar", &GasMix{FHe: 0.45, FN2: 0.37, FO2: 0.18}, 232.0, 1.0729, 2594.9},
+		{"Heliox 21/79 @ 300 bar", &GasMix{FHe: 0.79, FO2: 0.21}, 300.0, 1.1099, 3243.7},
+		{"Empty cylinder", NewAirMix(), 0.0, 1.0, 0.0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			z := math.Round(tt.gm.Compressibility(tt.pressure)*10000) / 10000
+			if z != tt.wantZ {
+				t.Errorf("Z want: %v; got: %v", tt.wantZ, z)
+			}
+
+			vol := math.Round(tt.gm.FreeGasVolume(12.0, tt.pressure)*10) / 10
+			if vol != tt.wantVol {
+				t.Errorf("volume want: %v; got: %v", tt.wantVol, vol)
+			}
+
+			p := tt.gm.PressureForVolume(12.0, tt.gm.FreeGasVolume(12.0, tt.pressure))
+			if math.Abs(p-tt.pressure) > 1e-6 {
+				t.Errorf("pressure want: %v; got: %v", tt.pressure, p)
+			}
+		})
+	}
+}
@@ -133,22 +133,56 @@ func (gm *GasMix) MixType() MixType {
 	return Unknown
 }
 
+// String() returns a short, human-readable name for the gas mix, e.g. "Air",
+// "EAN32", "O2", "Tx 21/35" or "Heliox 21/79".
+func (gm *GasMix) String() string {
+	o2 := math.Round(gm.FO2 * 100.0)
+	he := math.Round(gm.FHe * 100.0)
+
+	switch gm.MixType() {
+	case Air:
+		return "Air"
+	case Nitrox:
+		if o2 == 100.0 {
+			return "O2"
+		}
+		return fmt.Sprintf("EAN%.0f", o2)
+	case Trimix:
+		return fmt.Sprintf("Tx %.0f/%.0f", o2, he)
+	case Heliox:
+		return fmt.Sprintf("Heliox %.0f/%.0f", o2, he)
+	}
+	return fmt.Sprintf("%.0f/%.0f", o2, he)
+}
+
 // EAD() calculates the Nixtrox mix's Equivalent Air Depth in metres for a given
 // depth in metres.
 func (gm *GasMix) EAD(depth float64) float64 {
+	return gm.EADAt(depth, helpers.DefaultEnvironment())
+}
+
+// EADAt() calculates the Nitrox mix's Equivalent Air Depth in metres for a
+// given depth in metres in the given environment.
+func (gm *GasMix) EADAt(depth float64, env helpers.Environment) float64 {
 	// Use math.Abs() to handle the case where depth is represented as a
 	// negative number. The result of the calculation is the same.
 	d := math.Abs(depth)
 	// Calculate the fraction of Nitrogen.
 	fn2 := 1.0 - gm.FO2
 
-	return (d+10.0)*fn2/0.79 - 10.0
+	return env.Depth(env.Pressure(d) * fn2 / 0.79)
 }
 
 // MOD() calculates the gas mix's Maximum Operating Depth in metres for a given
 // maximum Partial Pressure of Oxygen in bar.
 func (gm *GasMix) MOD(maxPPO2 float64) float64 {
-	mod := 10.0 * (maxPPO2/gm.FO2 - 1.0)
+	return gm.MODAt(maxPPO2, helpers.DefaultEnvironment())
+}
+
+// MODAt() calculates the gas mix's Maximum Operating Depth in metres for a
+// given maximum Partial Pressure of Oxygen in bar in the given environment.
+func (gm *GasMix) MODAt(maxPPO2 float64, env helpers.Environment) float64 {
+	mod := env.Depth(maxPPO2 / gm.FO2)
 	// Round the result for clarity.
 	return math.Round(mod)
 }
@@ -156,26 +190,44 @@ func (gm *GasMix) MOD(maxPPO2 float64) float64 {
 // PPHe() returns the Partial Pressure of Helium for the gas mix at the given
 // depth in metres.
 func (gm *GasMix) PPHe(depth float64) float64 {
+	return gm.PPHeAt(depth, helpers.DefaultEnvironment())
+}
+
+// PPHeAt() returns the Partial Pressure of Helium for the gas mix at the given
+// depth in metres in the given environment.
+func (gm *GasMix) PPHeAt(depth float64, env helpers.Environment) float64 {
 	// Use math.Abs() to handle the case where depth is represented as a
 	// negative number. The result of the calculation is the same.
 	d := math.Abs(depth)
-	return helpers.Pressure(d) * gm.FO2
+	return env.Pressure(d) * gm.FHe
 }
 
 // PPN2() returns the Partial Pressure of Nitrogen for the Gas mix at the given
 // depth in metres.
 func (gm *GasMix) PPN2(depth float64) float64 {
+	return gm.PPN2At(depth, helpers.DefaultEnvironment())
+}
+
+// PPN2At() returns the Partial Pressure of Nitrogen for the gas mix at the
+// given depth in metres in the given environment.
+func (gm *GasMix) PPN2At(depth float64, env helpers.Environment) float64 {
 	// Use math.Abs() to handle the case where depth is represented as a
 	// negative number. The result of the calculation is the same.
 	d := math.Abs(depth)
-	return helpers.Pressure(d) * gm.FN2
+	return env.Pressure(d) * gm.FN2
 }
 
 // PPO2() returns the Partial Pressure of Oxygen for the gas mix at the given
 // depth in metres.
 func (gm *GasMix) PPO2(depth float64) float64 {
+	return gm.PPO2At(depth, helpers.DefaultEnvironment())
+}
+
+// PPO2At() returns the Partial Pressure of Oxygen for the gas mix at the given
+// depth in metres in the given environment.
+func (gm *GasMix) PPO2At(depth float64, env helpers.Environment) float64 {
 	// Use math.Abs() to handle the case where depth is represented as a
 	// negative number. The result of the calculation is the same.
 	d := math.Abs(depth)
-	return helpers.Pressure(d) * gm.FO2
+	return env.Pressure(d) * gm.FO2
 }
@@ -1,6 +1,10 @@
 package gasmix
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/m5lapp/diveplanner/helpers"
+)
 
 // TODO: TestNewMix()
 
@@ -111,3 +115,102 @@ func TestMOD(t *testing.T) {
 		})
 	}
 }
+
+func TestMODAt(t *testing.T) {
+	tests := []struct {
+		name string
+		fo2  float64
+		ppo2 float64
+		env  helpers.Environment
+		want float64
+	}{
+		{name: "32% @ 1.4 sea-level", fo2: 0.32, ppo2: 1.4, env: helpers.DefaultEnvironment(), want: 34.0},
+		{name: "32% @ 1.4 0.8 bar", fo2: 0.32, ppo2: 1.4, env: helpers.Environment{SurfacePressure: 0.8}, want: 36.0},
+		{name: "100% @ 1.6 0.8 bar", fo2: 1.00, ppo2: 1.6, env: helpers.Environment{SurfacePressure: 0.8}, want: 8.0},
+		{name: "21% @ 1.4 salt water", fo2: 0.21, ppo2: 1.4, env: helpers.Environment{WaterDensity: helpers.SaltWaterDensity}, want: 56.0},
+		{name: "21% @ 1.4 fresh water", fo2: 0.21, ppo2: 1.4, env: helpers.Environment{WaterDensity: helpers.FreshWaterDensity}, want: 58.0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gm, err := NewNitroxMix(tt.fo2)
+			if err != nil {
+				t.Fatalf("want %f; got error %v", tt.want, err)
+			}
+
+			if mod := gm.MODAt(tt.ppo2, tt.env); mod != tt.want {
+				t.Errorf("want %f; got %f", tt.want, mod)
+			}
+		})
+	}
+}
+
+func TestPartialPressures(t *testing.T) {
+	tests := []struct {
+		name     string
+		gm       GasMix
+		depth    float64
+		env      helpers.Environment
+		wantPPO2 float64
+		wantPPHe float64
+		wantPPN2 float64
+	}{
+		{name: "Air @ 30m", gm: GasMix{FN2: 0.79, FO2: 0.21}, depth: 30.0, wantPPO2: 0.84, wantPPHe: 0.0, wantPPN2: 3.16},
+		{name: "Trimix2135 @ 30m", gm: GasMix{FHe: 0.35, FN2: 0.44, FO2: 0.21}, depth: 30.0, wantPPO2: 0.84, wantPPHe: 1.4, wantPPN2: 1.76},
+		{name: "Trimix1845 @ -45m", gm: GasMix{FHe: 0.45, FN2: 0.37, FO2: 0.18}, depth: -45.0, wantPPO2: 0.99, wantPPHe: 2.475, wantPPN2: 2.035},
+		{name: "Heliox2179 @ 30m 0.8 bar", gm: GasMix{FHe: 0.79, FO2: 0.21}, depth: 30.0, env: helpers.Environment{SurfacePressure: 0.8}, wantPPO2: 0.798, wantPPHe: 3.002, wantPPN2: 0.0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if ppo2 := tt.gm.PPO2At(tt.depth, tt.env); !helpers.EqualFloat64(ppo2, tt.wantPPO2) {
+				t.Errorf("PPO2 want %f; got %f", tt.wantPPO2, ppo2)
+			}
+
+			if pphe := tt.gm.PPHeAt(tt.depth, tt.env); !helpers.EqualFloat64(pphe, tt.wantPPHe) {
+				t.Errorf("PPHe want %f; got %f", tt.wantPPHe, pphe)
+			}
+
+			if ppn2 := tt.gm.PPN2At(tt.depth, tt.env); !helpers.EqualFloat64(ppn2, tt.wantPPN2) {
+				t.Errorf("PPN2 want %f; got %f", tt.wantPPN2, ppn2)
+			}
+
+			// The sea-level functions should match the zero value Environment.
+			if tt.env == (helpers.Environment{}) {
+				if ppo2 := tt.gm.PPO2(tt.depth); !helpers.EqualFloat64(ppo2, tt.wantPPO2) {
+					t.Errorf("sea-level PPO2 want %f; got %f", tt.wantPPO2, ppo2)
+				}
+
+				if pphe := tt.gm.PPHe(tt.depth); !helpers.EqualFloat64(pphe, tt.wantPPHe) {
+					t.Errorf("sea-level PPHe want %f; got %f", tt.wantPPHe, pphe)
+				}
+
+				if ppn2 := tt.gm.PPN2(tt.depth); !helpers.EqualFloat64(ppn2, tt.wantPPN2) {
+					t.Errorf("sea-level PPN2 want %f; got %f", tt.wantPPN2, ppn2)
+				}
+			}
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		name string
+		gm   GasMix
+		want string
+	}{
+		{name: "Air", gm: GasMix{FN2: 0.79, FO2: 0.21}, want: "Air"},
+		{name: "Nitrox32", gm: GasMix{FN2: 0.68, FO2: 0.32}, want: "EAN32"},
+		{name: "Oxygen", gm: GasMix{FO2: 1.0}, want: "O2"},
+		{name: "Trimix2135", gm: GasMix{FHe: 0.35, FN2: 0.44, FO2: 0.21}, want: "Tx 21/35"},
+		{name: "Heliox2179", gm: GasMix{FHe: 0.79, FO2: 0.21}, want: "Heliox 21/79"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if s := tt.gm.String(); s != tt.want {
+				t.Errorf("want %s; got %s", tt.want, s)
+			}
+		})
+	}
+}
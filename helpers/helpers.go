@@ -9,19 +9,104 @@ func EqualFloat64(a, b float64) bool {
 	return math.Abs(a-b) <= float64EqualityThreshold
 }
 
+const (
+	// Standard atmospheric pressure at sea-level in bar.
+	StdAtmPressure float64 = 1.01325
+	// Atmospheric pressure in bar at sea-level used by the simplified
+	// sea-level calculations in this library.
+	seaLevelPressure float64 = 1.0
+	// Standard acceleration due to gravity in m/s².
+	gravity float64 = 9.80665
+
+	// Common water densities in kg/l.
+	FreshWaterDensity   float64 = 1.000
+	EN13319WaterDensity float64 = 1.020
+	SaltWaterDensity    float64 = 1.025
+)
+
+// Environment represents the conditions at a dive site that affect the
+// conversion between depth and pressure. The zero value is treated the same as
+// the one returned by DefaultEnvironment().
+type Environment struct {
+	// Absolute atmospheric pressure at the surface in bar.
+	SurfacePressure float64 `bson:"surface_pressure" json:"surface_pressure"`
+	// Density of the water in kg/l. If zero, the simplified value of ten
+	// metres of water per bar of pressure is used.
+	WaterDensity float64 `bson:"water_density" json:"water_density"`
+}
+
+// DefaultEnvironment() returns an Environment representing the simplified
+// sea-level conditions used throughout the library; a surface pressure of 1 bar
+// and ten metres of water per bar of pressure.
+func DefaultEnvironment() Environment {
+	return Environment{SurfacePressure: seaLevelPressure}
+}
+
+// AltitudePressure() calculates the atmospheric pressure in bar at a given
+// altitude in metres above sea-level using the barometric formula for the
+// International Standard Atmosphere. The result is scaled from the same 1 bar
+// sea-level pressure as DefaultEnvironment() so that an altitude of zero gives
+// the default surface pressure.
+func AltitudePressure(altitude float64) float64 {
+	return seaLevelPressure * math.Pow(1.0-2.25577e-5*altitude, 5.25588)
+}
+
+// NewAltitudeEnvironment() returns an Environment with the surface pressure
+// calculated from the given altitude in metres above sea-level.
+func NewAltitudeEnvironment(altitude float64) Environment {
+	return Environment{SurfacePressure: AltitudePressure(altitude)}
+}
+
+// surfacePressure() returns the environment's surface pressure in bar, falling
+// back to the default sea-level pressure if it has not been set.
+func (e Environment) surfacePressure() float64 {
+	if e.SurfacePressure <= 0.0 {
+		return seaLevelPressure
+	}
+	return e.SurfacePressure
+}
+
+// MetresPerBar() returns the depth of water in metres that exerts one bar of
+// pressure in the environment based on its water density.
+func (e Environment) MetresPerBar() float64 {
+	if e.WaterDensity <= 0.0 {
+		return 10.0
+	}
+	// One bar is 100,000 Pa and the density is converted to kg/m³.
+	return 100000.0 / (e.WaterDensity * 1000.0 * gravity)
+}
+
+// Depth() calculates the depth in metres for a given absolute pressure in bar
+// in the environment.
+func (e Environment) Depth(pressure float64) float64 {
+	return (pressure - e.surfacePressure()) * e.MetresPerBar()
+}
+
+// Pressure() calculates the absolute pressure in bar for a given depth in
+// metres in the environment.
+func (e Environment) Pressure(depth float64) float64 {
+	return depth/e.MetresPerBar() + e.surfacePressure()
+}
+
+// PressureChangePerMin() calculates the rate of pressure change in bar/min for
+// a given rate of ascent or descent in metres/min in the environment.
+func (e Environment) PressureChangePerMin(rate float64) float64 {
+	return rate / e.MetresPerBar()
+}
+
 // Depth() calculates the depth in metres for a given pressure in bar.
 func Depth(pressure float64) float64 {
-	return (pressure - 1.0) * 10.0
+	return DefaultEnvironment().Depth(pressure)
 }
 
 // Pressure() calculates the pressure in bar for a given depth in metres.
 func Pressure(depth float64) float64 {
-	return depth/10.0 + 1.0
+	return DefaultEnvironment().Pressure(depth)
 }
 
 // Pressure() calculates the pressure in bar for a given depth in metres.
 func PressureChangePerMin(rate float64) float64 {
-	return rate / 10.0
+	return DefaultEnvironment().PressureChangePerMin(rate)
 }
 
 // DescOrAsc() indicates whether a diver is descending (positive pressure delta,
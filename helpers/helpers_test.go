@@ -1,6 +1,9 @@
 package helpers
 
-import "testing"
+import (
+	"math"
+	"testing"
+)
 
 func TestDepth(t *testing.T) {
 	tests := []struct {
@@ -54,3 +57,61 @@ func TestPressure(t *testing.T) {
 		})
 	}
 }
+
+func TestAltitudePressure(t *testing.T) {
+	tests := []struct {
+		name     string
+		altitude float64
+		want     float64
+	}{
+		{name: "Sea-level", altitude: 0.0, want: 1.0},
+		{name: "Lake Geneva", altitude: 372.0, want: 0.957},
+		{name: "1000m", altitude: 1000.0, want: 0.887},
+		{name: "Lake Titicaca", altitude: 3812.0, want: 0.623},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := math.Round(AltitudePressure(tt.altitude)*1000.0) / 1000.0
+
+			if p != tt.want {
+				t.Errorf("want %f; got %f", tt.want, p)
+			}
+		})
+	}
+
+	if got, want := NewAltitudeEnvironment(0.0), DefaultEnvironment(); got != want {
+		t.Errorf("sea-level environment want: %+v; got: %+v", want, got)
+	}
+}
+
+func TestEnvironment(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       Environment
+		depth     float64
+		wantPress float64
+	}{
+		{name: "Zero value", env: Environment{}, depth: 18.0, wantPress: 2.8},
+		{name: "Default", env: DefaultEnvironment(), depth: 18.0, wantPress: 2.8},
+		{name: "Surface at 0.8 bar", env: Environment{SurfacePressure: 0.8}, depth: 0.0, wantPress: 0.8},
+		{name: "18m at 0.8 bar", env: Environment{SurfacePressure: 0.8}, depth: 18.0, wantPress: 2.6},
+		{name: "18m salt water", env: Environment{WaterDensity: SaltWaterDensity}, depth: 18.0, wantPress: 2.8093},
+		{name: "18m fresh water", env: Environment{WaterDensity: FreshWaterDensity}, depth: 18.0, wantPress: 2.7652},
+		{name: "18m EN13319", env: Environment{WaterDensity: EN13319WaterDensity}, depth: 18.0, wantPress: 2.8005},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.env.Pressure(tt.depth)
+			if !EqualFloat64(math.Round(p*10000.0)/10000.0, tt.wantPress) {
+				t.Errorf("pressure want %f; got %f", tt.wantPress, p)
+			}
+
+			d := tt.env.Depth(p)
+			if !EqualFloat64(d, tt.depth) {
+				t.Errorf("depth want %f; got %f", tt.depth, d)
+			}
+		})
+	}
+}
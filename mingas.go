@@ -0,0 +1,210 @@
+package diveplanner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/m5lapp/diveplanner/deco"
+)
+
+// MinGasProfile configures how the minimum, or rock bottom, gas for a dive is
+// calculated. The diver's SAC rate is multiplied by the plan's DiveFactor, the
+// StressFactor and the BuddyMultiplier to give the elevated SAC rate in an
+// emergency. The minimum gas is then the gas breathed at this rate during the
+// ProblemSolvingTime in minutes at the deepest point, the ascent to the surface
+// and a safety stop of SafetyStopTime minutes at SafetyStopDepth metres.
+type MinGasProfile struct {
+	ProblemSolvingTime float64 `bson:"problem_solving_time" json:"problem_solving_time"`
+	StressFactor       float64 `bson:"stress_factor" json:"stress_factor"`
+	BuddyMultiplier    float64 `bson:"buddy_multiplier" json:"buddy_multiplier"`
+	SafetyStopTime     float64 `bson:"safety_stop_time" json:"safety_stop_time"`
+	SafetyStopDepth    float64 `bson:"safety_stop_depth" json:"safety_stop_depth"`
+	// If true, the ascent also includes each of the decompression stops
+	// required at the end of the time spent at the deepest point of the plan.
+	// For plans with Cylinders, each stop is only included for the cylinder
+	// that it is breathed from.
+	IncludeDeco bool `bson:"include_deco" json:"include_deco"`
+}
+
+// DefaultMinGasProfile allows one minute to solve the problem, a stress factor
+// of 1.5, enough gas for two divers and a three minute safety stop at five
+// metres.
+var DefaultMinGasProfile = MinGasProfile{
+	ProblemSolvingTime: 1.0,
+	StressFactor:       1.5,
+	BuddyMultiplier:    2.0,
+	SafetyStopTime:     3.0,
+	SafetyStopDepth:    safetyStopDepth,
+}
+
+// Validate() validates a MinGasProfile struct, it will return a slice of
+// errors which will be empty if there are no errors.
+func (p MinGasProfile) Validate() []error {
+	var errs []error
+
+	errs = numInRange("Problem Solving Time", p.ProblemSolvingTime, 0.0, 10.0, errs)
+	errs = numInRange("Stress Factor", p.StressFactor, 1.0, 3.0, errs)
+	errs = numInRange("Buddy Multiplier", p.BuddyMultiplier, 1.0, 3.0, errs)
+	errs = numInRange("Safety Stop Time", p.SafetyStopTime, 0.0, 10.0, errs)
+	if p.SafetyStopTime > 0.0 {
+		errs = numInRange("Safety Stop Depth", p.SafetyStopDepth, 3.0, 6.0, errs)
+	}
+
+	return errs
+}
+
+// MinGasComponent is one part of the minimum gas; the gas in litres at the
+// surface breathed at the elevated SAC rate for Duration minutes at an average
+// depth of Depth metres and an ambient Pressure in bar.
+type MinGasComponent struct {
+	Description string  `bson:"description" json:"description"`
+	Depth       float64 `bson:"depth" json:"depth"`
+	Pressure    float64 `bson:"pressure" json:"pressure"`
+	Duration    float64 `bson:"duration" json:"duration"`
+	Gas         float64 `bson:"gas" json:"gas"`
+}
+
+// MinGasBreakdown explains how the minimum gas to get to the surface from
+// Depth metres is calculated. ElevatedSACRate is the SACRate multiplied by the
+// DiveFactor and the profile's StressFactor and BuddyMultiplier and MinGas is
+// the sum of the gas in each of the Components.
+type MinGasBreakdown struct {
+	Profile         MinGasProfile     `bson:"profile" json:"profile"`
+	Depth           float64           `bson:"depth" json:"depth"`
+	SACRate         float64           `bson:"sac_rate" json:"sac_rate"`
+	DiveFactor      float64           `bson:"dive_factor" json:"dive_factor"`
+	ElevatedSACRate float64           `bson:"elevated_sac_rate" json:"elevated_sac_rate"`
+	Components      []MinGasComponent `bson:"components" json:"components"`
+	MinGas          float64           `bson:"min_gas" json:"min_gas"`
+}
+
+// String() returns a description of the breakdown with one line for the
+// elevated SAC rate and each of the components.
+func (b *MinGasBreakdown) String() string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "Minimum gas from %.1fm: %.0fl\n", b.Depth, b.MinGas)
+	fmt.Fprintf(&sb, "Elevated SAC rate: %.1fl/min x %.1f dive factor x %.1f stress factor x %.1f buddy multiplier = %.1fl/min\n",
+		b.SACRate, b.DiveFactor, b.Profile.StressFactor, b.Profile.BuddyMultiplier, b.ElevatedSACRate)
+	for _, c := range b.Components {
+		fmt.Fprintf(&sb, "%s: %.1fmin at %.1fm (%.2f bar) = %.0fl\n", c.Description, c.Duration, c.Depth, c.Pressure, c.Gas)
+	}
+
+	return sb.String()
+}
+
+// minGasProfile() returns the plan's MinGasProfile or the default one if it is
+// not set.
+func (dp *DivePlan) minGasProfile() MinGasProfile {
+	if dp.MinGasProfile != nil {
+		return *dp.MinGasProfile
+	}
+	return DefaultMinGasProfile
+}
+
+// MinGasBreakdown() returns the breakdown of the minimum gas to get to the
+// surface from the deepest point of the dive, see MinGas().
+func (dp *DivePlan) MinGasBreakdown() *MinGasBreakdown {
+	return dp.minGasBreakdown(dp.MaxDepth(), -1)
+}
+
+// minGasBreakdown() returns the breakdown of the minimum gas to get to the
+// surface in an emergency from the given depth in metres for the cylinder at
+// the given index of the plan's Cylinders. Each decompression stop is only
+// included for the cylinder that it is breathed from. If cylinder is negative,
+// then all of the decompression stops are included.
+func (dp *DivePlan) minGasBreakdown(maxDepth float64, cylinder int) *MinGasBreakdown {
+	env := dp.Environment()
+	p := dp.minGasProfile()
+	b := &MinGasBreakdown{
+		Profile:    p,
+		Depth:      maxDepth,
+		SACRate:    dp.SACRate,
+		DiveFactor: dp.DiveFactor,
+	}
+
+	// Account for the elevated breathing rate in an emergency.
+	b.ElevatedSACRate = dp.SACRate * dp.DiveFactor * p.StressFactor * p.BuddyMultiplier
+
+	add := func(desc string, depth, duration float64) {
+		pressure := env.Pressure(depth)
+		gas := duration * pressure * b.ElevatedSACRate
+		b.Components = append(b.Components, MinGasComponent{
+			Description: desc,
+			Depth:       depth,
+			Pressure:    pressure,
+			Duration:    duration,
+			Gas:         gas,
+		})
+		b.MinGas += gas
+	}
+
+	// Allow time to sort yourself out at the maximum depth.
+	add("Problem solving", maxDepth, p.ProblemSolvingTime)
+
+	// Gas required for the ascent to reach the surface breathing at the average
+	// depth ambient pressure. As the ascent rate is constant, this is the same
+	// whether or not the ascent is broken up by decompression stops.
+	add("Ascent", maxDepth/2.0, dp.transitionDuration(maxDepth, 0.0))
+
+	var decoStops []minGasDecoStop
+	if p.IncludeDeco {
+		decoStops = dp.minGasDecoStops(maxDepth)
+	}
+
+	for _, ds := range decoStops {
+		if cylinder < 0 || ds.cylinder == cylinder {
+			add(fmt.Sprintf("Decompression stop on %s", ds.GasMix), ds.Depth, ds.Duration)
+		}
+	}
+
+	if p.SafetyStopTime > 0.0 {
+		add("Safety stop", p.SafetyStopDepth, p.SafetyStopTime)
+	}
+
+	return b
+}
+
+// minGasDecoStop is a decompression stop along with the index of the cylinder
+// that it is breathed from, see cylinderFor().
+type minGasDecoStop struct {
+	deco.Stop
+	cylinder int
+}
+
+// minGasDecoStops() returns the decompression stops that are no deeper than the
+// given depth in metres from the schedule required at the end of the last stop
+// at the deepest point of the plan.
+func (dp *DivePlan) minGasDecoStops(maxDepth float64) []minGasDecoStop {
+	deepest := -1
+	planMaxDepth := dp.MaxDepth()
+	for i, s := range dp.Stops {
+		if !s.IsTransition && s.Depth > 0.0 && s.Duration > 0.0 && s.Depth == planMaxDepth {
+			deepest = i
+		}
+	}
+
+	if deepest < 0 {
+		return nil
+	}
+
+	m := dp.configuredDecoModel()
+	dp.modelStops(m, dp.Stops[:deepest+1])
+
+	// The cylinders are assigned to the stops in the same way as in
+	// DiveProfile().
+	// A stop that cannot be completed is capped at deco.MaxStopTime, which
+	// still gives a minimum gas that cannot be carried.
+	decoStops, _ := m.DecoStops(dp.AscentRate)
+
+	var stops []minGasDecoStop
+	currCyl := dp.Stops[deepest].Cylinder
+	for _, ds := range decoStops {
+		currCyl = dp.cylinderFor(ds.GasMix, currCyl)
+		if ds.Depth <= maxDepth {
+			stops = append(stops, minGasDecoStop{Stop: ds, cylinder: currCyl})
+		}
+	}
+
+	return stops
+}
@@ -0,0 +1,123 @@
+package diveplanner
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/m5lapp/diveplanner/helpers"
+)
+
+func TestMinGasBreakdown(t *testing.T) {
+	custom := MinGasProfile{ProblemSolvingTime: 2.0, StressFactor: 1.2, BuddyMultiplier: 1.0}
+	withDeco := DefaultMinGasProfile
+	withDeco.IncludeDeco = true
+
+	tests := []struct {
+		name           string
+		duration       float64
+		profile        *MinGasProfile
+		wantSACRate    float64
+		wantComponents []string
+		wantGas        []float64
+		wantMinGas     float64
+		// The minimum gas for each cylinder.
+		wantCylinders [2]float64
+		// The gas for decompression stops in each cylinder's minimum gas.
+		wantCylinderDeco [2]float64
+	}{
+		{
+			name:           "Default profile",
+			duration:       20,
+			wantSACRate:    67.5,
+			wantComponents: []string{"Problem solving", "Ascent", "Safety stop"},
+			wantGas:        []float64{270, 675, 303.75},
+			wantMinGas:     1248.75,
+			wantCylinders:  [2]float64{1248.75, 0},
+		}, {
+			name:           "Custom profile without a safety stop",
+			duration:       20,
+			profile:        &custom,
+			wantSACRate:    27,
+			wantComponents: []string{"Problem solving", "Ascent"},
+			wantGas:        []float64{216, 270},
+			wantMinGas:     486,
+			wantCylinders:  [2]float64{486, 0},
+		}, {
+			name:           "Including deco stops",
+			duration:       40,
+			profile:        &withDeco,
+			wantSACRate:    67.5,
+			wantComponents: []string{"Problem solving", "Ascent", "Decompression stop on EAN50", "Safety stop"},
+			wantGas:        []float64{270, 675, 263.25, 303.75},
+			wantMinGas:     1512,
+			// The EAN50 stop at 3m is only charged to the deco cylinder,
+			// which is 87.75l to solve problems at 3m, 77.625l for the
+			// ascent, 263.25l for the stop and 303.75l for the safety stop.
+			wantCylinders:    [2]float64{1248.75, 732.375},
+			wantCylinderDeco: [2]float64{0, 263.25},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dp := newCylinderTestPlan(tt.duration)
+			dp.MinGasProfile = tt.profile
+			if errs := dp.Validate(); len(errs) != 0 {
+				t.Fatalf("want no errors; got: %v", errs)
+			}
+
+			b := dp.MinGasBreakdown()
+			if b.Depth != 30.0 || !helpers.EqualFloat64(b.ElevatedSACRate, tt.wantSACRate) {
+				t.Errorf("want: 30m at %vl/min; got: %vm at %vl/min", tt.wantSACRate, b.Depth, b.ElevatedSACRate)
+			}
+
+			var names []string
+			var gas []float64
+			for _, c := range b.Components {
+				names = append(names, c.Description)
+				gas = append(gas, c.Gas)
+			}
+			if fmt.Sprint(names) != fmt.Sprint(tt.wantComponents) || fmt.Sprint(gas) != fmt.Sprint(tt.wantGas) {
+				t.Errorf("want: %v %v; got: %v %v", tt.wantComponents, tt.wantGas, names, gas)
+			}
+
+			if !helpers.EqualFloat64(b.MinGas, tt.wantMinGas) {
+				t.Errorf("min gas want: %v; got: %v", tt.wantMinGas, b.MinGas)
+			}
+
+			for i, cg := range dp.CylinderGas() {
+				if !helpers.EqualFloat64(cg.MinGas, tt.wantCylinders[i]) {
+					t.Errorf("cylinder %d min gas want: %v; got: %v", i, tt.wantCylinders[i], cg.MinGas)
+				}
+
+				var decoGas float64
+				for _, c := range dp.minGasBreakdown(30.0, i).Components {
+					if strings.HasPrefix(c.Description, "Decompression stop") {
+						decoGas += c.Gas
+					}
+				}
+				if !helpers.EqualFloat64(decoGas, tt.wantCylinderDeco[i]) {
+					t.Errorf("cylinder %d deco gas want: %v; got: %v", i, tt.wantCylinderDeco[i], decoGas)
+				}
+			}
+
+			if s := b.String(); !strings.HasPrefix(s, "Minimum gas from 30.0m") {
+				t.Errorf("unexpected description: %s", s)
+			}
+		})
+	}
+
+	t.Run("Invalid profile", func(t *testing.T) {
+		dp := newCylinderTestPlan(20)
+		dp.MinGasProfile = &MinGasProfile{StressFactor: 0.5, BuddyMultiplier: 2.0, SafetyStopTime: 3.0, SafetyStopDepth: 10.0}
+
+		want := []string{
+			"Stress Factor value (0.5) must be between 1 and 3 inclusive",
+			"Safety Stop Depth value (10) must be between 3 and 6 inclusive",
+		}
+		if got := fmt.Sprint(dp.Validate()); got != fmt.Sprint(want) {
+			t.Errorf("want: %v; got: %s", want, got)
+		}
+	})
+}
@@ -0,0 +1,240 @@
+package oxygen
+
+// Sources of information used for the Oxygen toxicity calculations:
+//   NOAA Diving Manual, Oxygen Partial Pressure Limits for "Normal" Exposures
+//   https://en.wikipedia.org/wiki/Oxygen_toxicity
+//   Hamilton, R.W. (1989) Tolerating Exposure to High Oxygen Levels: Repex
+//   and Other Methods.
+//   Baker, E.C. Oxygen Toxicity Calculations.
+
+import "math"
+
+const (
+	// CNS% at which a warning should be given.
+	CNSWarningLimit float64 = 80.0
+	// Maximum CNS% that should not be exceeded.
+	CNSMaxLimit float64 = 100.0
+	// Half-life in minutes of the CNS% whilst at the surface.
+	CNSHalfLife float64 = 90.0
+	// PPO2 in bar below which there is no CNS Oxygen loading.
+	minPPO2 float64 = 0.5
+	// Number of steps used to integrate the CNS% for a change in PPO2.
+	integrationSteps int = 100
+	// Exponent used in the OTU dose equation.
+	otuExponent float64 = 0.83
+)
+
+// The REPEX average daily OTU dose limits, indexed by the number of days of
+// exposure minus one. Exposures of more than 14 days use the last value.
+var repexDailyLimits = []float64{
+	850.0, 700.0, 620.0, 525.0, 460.0, 420.0, 380.0,
+	350.0, 330.0, 310.0, 300.0, 300.0, 300.0, 300.0,
+}
+
+// noaaLimit represents the maximum single exposure time in minutes for a given
+// Partial Pressure of Oxygen in bar.
+type noaaLimit struct {
+	ppo2  float64
+	limit float64
+}
+
+// The NOAA single exposure limits, ordered by PPO2.
+var noaaLimits = []noaaLimit{
+	{ppo2: 0.6, limit: 720.0},
+	{ppo2: 0.7, limit: 570.0},
+	{ppo2: 0.8, limit: 450.0},
+	{ppo2: 0.9, limit: 360.0},
+	{ppo2: 1.0, limit: 300.0},
+	{ppo2: 1.1, limit: 240.0},
+	{ppo2: 1.2, limit: 210.0},
+	{ppo2: 1.3, limit: 180.0},
+	{ppo2: 1.4, limit: 150.0},
+	{ppo2: 1.5, limit: 120.0},
+	{ppo2: 1.6, limit: 45.0},
+}
+
+// Custom type to represent the severity of a CNS% value.
+type CNSLevel int
+
+const (
+	CNSOk CNSLevel = iota
+	CNSWarning
+	CNSExceeded
+)
+
+func (l CNSLevel) String() string {
+	switch l {
+	case CNSOk:
+		return "OK"
+	case CNSWarning:
+		return "Warning"
+	case CNSExceeded:
+		return "Exceeded"
+	}
+	return "Unknown CNS Level"
+}
+
+// Level() returns the appropriate CNSLevel constant for the given CNS%.
+func Level(cns float64) CNSLevel {
+	if cns > CNSMaxLimit {
+		return CNSExceeded
+	} else if cns > CNSWarningLimit {
+		return CNSWarning
+	}
+	return CNSOk
+}
+
+// SingleExposureLimit() returns the NOAA maximum single exposure time in
+// minutes for the given PPO2 in bar, linearly interpolated between the values
+// in the table. PPO2 values below 0.6 bar use the 0.6 bar limit. NOAA gives no
+// limits above 1.6 bar, so the CNS% per minute, 100 / limit, of the last two
+// values in the table is extrapolated linearly instead, which gives about 28
+// minutes at 1.7 bar and 13 minutes at 2.0 bar. Zero is returned for PPO2
+// values below 0.5 bar as they do not contribute to CNS Oxygen toxicity.
+func SingleExposureLimit(ppo2 float64) float64 {
+	if ppo2 < minPPO2 {
+		return 0.0
+	}
+
+	first, last := noaaLimits[0], noaaLimits[len(noaaLimits)-1]
+	if ppo2 <= first.ppo2 {
+		return first.limit
+	} else if ppo2 > last.ppo2 {
+		prev := noaaLimits[len(noaaLimits)-2]
+		lastRate, prevRate := 100.0/last.limit, 100.0/prev.limit
+		slope := (lastRate - prevRate) / (last.ppo2 - prev.ppo2)
+		return 100.0 / (lastRate + slope*(ppo2-last.ppo2))
+	}
+
+	for i := 1; i < len(noaaLimits); i++ {
+		lo, hi := noaaLimits[i-1], noaaLimits[i]
+		if ppo2 <= hi.ppo2 {
+			frac := (ppo2 - lo.ppo2) / (hi.ppo2 - lo.ppo2)
+			return lo.limit + frac*(hi.limit-lo.limit)
+		}
+	}
+
+	return last.limit
+}
+
+// CNSRate() returns the CNS% accumulated per minute at the given PPO2 in bar.
+func CNSRate(ppo2 float64) float64 {
+	limit := SingleExposureLimit(ppo2)
+	if limit == 0.0 {
+		return 0.0
+	}
+	return 100.0 / limit
+}
+
+// CNSConstant() returns the CNS% accumulated by breathing a constant PPO2 in
+// bar for the given time in minutes, for example during a stop.
+func CNSConstant(ppo2, time float64) float64 {
+	return CNSRate(ppo2) * time
+}
+
+// CNSTransition() returns the CNS% accumulated during the given time in
+// minutes whilst the PPO2 changes linearly from startPPO2 to endPPO2, for
+// example during an ascent or descent. The CNS% rate is integrated over the
+// transition using the midpoint rule.
+func CNSTransition(startPPO2, endPPO2, time float64) float64 {
+	var cns float64
+	step := time / float64(integrationSteps)
+	delta := (endPPO2 - startPPO2) / float64(integrationSteps)
+
+	for i := 0; i < integrationSteps; i++ {
+		ppo2 := startPPO2 + delta*(float64(i)+0.5)
+		cns += CNSRate(ppo2) * step
+	}
+
+	return cns
+}
+
+// CNSDecay() returns the remaining CNS% after spending the given time in
+// minutes at the surface.
+func CNSDecay(cns, time float64) float64 {
+	return cns * math.Pow(0.5, time/CNSHalfLife)
+}
+
+// OTUConstant() returns the number of Oxygen Tolerance Units (OTUs) accumulated
+// by breathing a constant PPO2 in bar for the given time in minutes. PPO2 values
+// of 0.5 bar or below do not produce any OTUs.
+func OTUConstant(ppo2, time float64) float64 {
+	if ppo2 <= minPPO2 {
+		return 0.0
+	}
+	return time * math.Pow((ppo2-minPPO2)/minPPO2, otuExponent)
+}
+
+// OTUTransition() returns the number of OTUs accumulated during the given time
+// in minutes whilst the PPO2 changes linearly from startPPO2 to endPPO2, for
+// example during an ascent or descent. This uses the integrated form of the OTU
+// equation and only the portion of the transition above 0.5 bar contributes.
+func OTUTransition(startPPO2, endPPO2, time float64) float64 {
+	if math.Abs(endPPO2-startPPO2) < 1e-9 {
+		return OTUConstant(startPPO2, time)
+	}
+
+	// otuIntegral() is the indefinite integral of the OTU dose with respect to
+	// PPO2, treating any PPO2 of 0.5 bar or below as contributing nothing.
+	otuIntegral := func(ppo2 float64) float64 {
+		x := math.Max((ppo2-minPPO2)/minPPO2, 0.0)
+		return minPPO2 * math.Pow(x, otuExponent+1.0) / (otuExponent + 1.0)
+	}
+
+	return time / (endPPO2 - startPPO2) * (otuIntegral(endPPO2) - otuIntegral(startPPO2))
+}
+
+// REPEXDailyLimit() returns the REPEX average daily OTU limit for an exposure
+// lasting the given number of days.
+func REPEXDailyLimit(days int) float64 {
+	if days < 1 {
+		return 0.0
+	} else if days > len(repexDailyLimits) {
+		return repexDailyLimits[len(repexDailyLimits)-1]
+	}
+	return repexDailyLimits[days-1]
+}
+
+// REPEXTotalLimit() returns the REPEX total OTU limit for an exposure lasting
+// the given number of days.
+func REPEXTotalLimit(days int) float64 {
+	return REPEXDailyLimit(days) * float64(days)
+}
+
+// REPEXDay represents the OTU exposure on one day of a multi-day exposure and
+// how it compares to the REPEX limits.
+type REPEXDay struct {
+	Day             int     `bson:"day" json:"day"`
+	OTU             float64 `bson:"otu" json:"otu"`
+	CumulativeOTU   float64 `bson:"cumulative_otu" json:"cumulative_otu"`
+	CumulativeLimit float64 `bson:"cumulative_limit" json:"cumulative_limit"`
+	SingleDayLimit  float64 `bson:"single_day_limit" json:"single_day_limit"`
+	WithinLimits    bool    `bson:"within_limits" json:"within_limits"`
+}
+
+// CheckREPEX() returns a cumulative tally of the given total OTUs for each
+// consecutive day and checks each day against the REPEX limits. A day is within
+// limits if it does not exceed the single day limit of 850 OTUs and the
+// cumulative total does not exceed the REPEX total for that many days.
+func CheckREPEX(dailyOTUs []float64) []REPEXDay {
+	var tally []REPEXDay
+	var cumulative float64
+
+	for i, otu := range dailyOTUs {
+		day := i + 1
+		cumulative += otu
+		totalLimit := REPEXTotalLimit(day)
+		singleLimit := REPEXDailyLimit(1)
+
+		tally = append(tally, REPEXDay{
+			Day:             day,
+			OTU:             otu,
+			CumulativeOTU:   cumulative,
+			CumulativeLimit: totalLimit,
+			SingleDayLimit:  singleLimit,
+			WithinLimits:    otu <= singleLimit && cumulative <= totalLimit,
+		})
+	}
+
+	return tally
+}
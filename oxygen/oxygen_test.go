@@ -0,0 +1,214 @@
+package oxygen
+
+import (
+	"math"
+	"testing"
+)
+
+// round() rounds a value to three decimal places for comparison in tests.
+func round(val float64) float64 {
+	return math.Round(val*1000.0) / 1000.0
+}
+
+func TestSingleExposureLimit(t *testing.T) {
+	tests := []struct {
+		name string
+		ppo2 float64
+		want float64
+	}{
+		{name: "Air at the surface", ppo2: 0.21, want: 0.0},
+		{name: "0.55 bar", ppo2: 0.55, want: 720.0},
+		{name: "0.6 bar", ppo2: 0.6, want: 720.0},
+		{name: "1.0 bar", ppo2: 1.0, want: 300.0},
+		{name: "1.25 bar", ppo2: 1.25, want: 195.0},
+		{name: "1.4 bar", ppo2: 1.4, want: 150.0},
+		{name: "1.55 bar", ppo2: 1.55, want: 82.5},
+		{name: "1.6 bar", ppo2: 1.6, want: 45.0},
+		// Above 1.6 bar, the CNS% per minute of 0.833 at 1.5 bar and 2.222
+		// at 1.6 bar is extrapolated, e.g. 5% per minute at 1.8 bar.
+		{name: "1.7 bar", ppo2: 1.7, want: 27.692},
+		{name: "1.8 bar", ppo2: 1.8, want: 20.0},
+		{name: "2.0 bar", ppo2: 2.0, want: 12.857},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit := round(SingleExposureLimit(tt.ppo2))
+			if limit != tt.want {
+				t.Errorf("want: %f; got: %f", tt.want, limit)
+			}
+		})
+	}
+}
+
+func TestCNS(t *testing.T) {
+	tests := []struct {
+		name      string
+		startPPO2 float64
+		endPPO2   float64
+		time      float64
+		want      float64
+	}{
+		{name: "Constant 1.4 bar for 30min", startPPO2: 1.4, endPPO2: 1.4, time: 30.0, want: 20.0},
+		{name: "Constant 1.6 bar for 45min", startPPO2: 1.6, endPPO2: 1.6, time: 45.0, want: 100.0},
+		{name: "Constant 1.8 bar for 25min", startPPO2: 1.8, endPPO2: 1.8, time: 25.0, want: 125.0},
+		{name: "Constant 0.4 bar for 60min", startPPO2: 0.4, endPPO2: 0.4, time: 60.0, want: 0.0},
+		{name: "Descent 0.32 to 1.28 bar", startPPO2: 0.32, endPPO2: 1.28, time: 2.0, want: 0.471},
+		{name: "Ascent 1.4 to 1.0 bar", startPPO2: 1.4, endPPO2: 1.0, time: 4.0, want: 1.939},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cns float64
+			if tt.startPPO2 == tt.endPPO2 {
+				cns = CNSConstant(tt.startPPO2, tt.time)
+			} else {
+				cns = CNSTransition(tt.startPPO2, tt.endPPO2, tt.time)
+			}
+
+			if round(cns) != tt.want {
+				t.Errorf("want: %f; got: %f", tt.want, cns)
+			}
+		})
+	}
+}
+
+func TestCNSDecay(t *testing.T) {
+	tests := []struct {
+		name string
+		cns  float64
+		time float64
+		want float64
+	}{
+		{name: "No surface interval", cns: 80.0, time: 0.0, want: 80.0},
+		{name: "One half-life", cns: 80.0, time: 90.0, want: 40.0},
+		{name: "Two half-lives", cns: 80.0, time: 180.0, want: 20.0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if cns := round(CNSDecay(tt.cns, tt.time)); cns != tt.want {
+				t.Errorf("want: %f; got: %f", tt.want, cns)
+			}
+		})
+	}
+}
+
+func TestLevel(t *testing.T) {
+	tests := []struct {
+		name string
+		cns  float64
+		want CNSLevel
+		str  string
+	}{
+		{name: "OK", cns: 45.0, want: CNSOk, str: "OK"},
+		{name: "Warning limit", cns: 80.0, want: CNSOk, str: "OK"},
+		{name: "Warning", cns: 80.1, want: CNSWarning, str: "Warning"},
+		{name: "Exceeded", cns: 100.1, want: CNSExceeded, str: "Exceeded"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := Level(tt.cns)
+			if l != tt.want {
+				t.Errorf("want %v; got %v", tt.want, l)
+			}
+
+			if l.String() != tt.str {
+				t.Errorf("want string %s; got %s", tt.str, l.String())
+			}
+		})
+	}
+}
+
+func TestOTU(t *testing.T) {
+	tests := []struct {
+		name      string
+		startPPO2 float64
+		endPPO2   float64
+		time      float64
+		want      float64
+	}{
+		{name: "Constant 1.0 bar for 30min", startPPO2: 1.0, endPPO2: 1.0, time: 30.0, want: 30.0},
+		{name: "Constant 1.4 bar for 30min", startPPO2: 1.4, endPPO2: 1.4, time: 30.0, want: 48.865},
+		{name: "Constant 0.5 bar for 60min", startPPO2: 0.5, endPPO2: 0.5, time: 60.0, want: 0.0},
+		{name: "Descent 0.32 to 1.28 bar", startPPO2: 0.32, endPPO2: 1.28, time: 2.0, want: 1.284},
+		{name: "Ascent 1.4 to 1.0 bar", startPPO2: 1.4, endPPO2: 1.0, time: 4.0, want: 5.278},
+		{name: "Descent 0.2 to 0.45 bar", startPPO2: 0.2, endPPO2: 0.45, time: 3.0, want: 0.0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var otu float64
+			if tt.startPPO2 == tt.endPPO2 {
+				otu = OTUConstant(tt.startPPO2, tt.time)
+			} else {
+				otu = OTUTransition(tt.startPPO2, tt.endPPO2, tt.time)
+			}
+
+			if round(otu) != tt.want {
+				t.Errorf("want: %f; got: %f", tt.want, otu)
+			}
+
+			// A transition with no change in PPO2 should match a stop.
+			if tt.startPPO2 == tt.endPPO2 {
+				if trans := OTUTransition(tt.startPPO2, tt.endPPO2, tt.time); trans != otu {
+					t.Errorf("transition want: %f; got: %f", otu, trans)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckREPEX(t *testing.T) {
+	tests := []struct {
+		name      string
+		dailyOTUs []float64
+		want      []bool
+	}{
+		{name: "Single day", dailyOTUs: []float64{600.0}, want: []bool{true}},
+		{name: "Single day exceeded", dailyOTUs: []float64{900.0}, want: []bool{false}},
+		{name: "Three days", dailyOTUs: []float64{600.0, 600.0, 600.0}, want: []bool{true, true, true}},
+		{name: "Cumulative exceeded", dailyOTUs: []float64{700.0, 720.0, 300.0}, want: []bool{true, false, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tally := CheckREPEX(tt.dailyOTUs)
+			if len(tally) != len(tt.want) {
+				t.Fatalf("want %d days; got: %d", len(tt.want), len(tally))
+			}
+
+			var cumulative float64
+			for i, d := range tally {
+				cumulative += tt.dailyOTUs[i]
+				if d.Day != i+1 || d.CumulativeOTU != cumulative {
+					t.Errorf("day %d want: %d, %f; got: %d, %f", i+1, i+1, cumulative, d.Day, d.CumulativeOTU)
+				}
+
+				if d.WithinLimits != tt.want[i] {
+					t.Errorf("day %d within limits want: %v; got: %v", i+1, tt.want[i], d.WithinLimits)
+				}
+			}
+		})
+	}
+}
+
+func TestREPEXTotalLimit(t *testing.T) {
+	tests := []struct {
+		days int
+		want float64
+	}{
+		{days: 1, want: 850.0},
+		{days: 2, want: 1400.0},
+		{days: 5, want: 2300.0},
+		{days: 10, want: 3100.0},
+		{days: 20, want: 6000.0},
+	}
+
+	for _, tt := range tests {
+		if limit := REPEXTotalLimit(tt.days); limit != tt.want {
+			t.Errorf("%d days want: %f; got: %f", tt.days, tt.want, limit)
+		}
+	}
+}
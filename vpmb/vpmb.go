@@ -0,0 +1,718 @@
+package vpmb
+
+// Sources of information used for the VPM-B algorithm:
+//   Baker, E.B. VPMDECO and VPM-B FORTRAN source code.
+//   Yount, D.E. and Hoffman, D.C. (1986) On the use of a bubble formation
+//   model to calculate diving tables.
+//   https://github.com/subsurface/subsurface/blob/master/core/deco.cpp
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/m5lapp/diveplanner/deco"
+	"github.com/m5lapp/diveplanner/gasmix"
+	"github.com/m5lapp/diveplanner/helpers"
+)
+
+const (
+	compartCount = 16
+	// Partial pressure of water vapour in the lungs in bar as used by Baker,
+	// equivalent to 1.607 fsw.
+	pH2O = 0.0493
+	// Pressure in bar of the other gases in the tissues (O2, CO2 and water
+	// vapour), equivalent to 102 mmHg.
+	pOtherGases = 0.1359888
+	// Surface tension of the bubble skin (gamma) in N/m.
+	surfaceTension = 0.0179
+	// Skin compression of the bubble skin (gamma c) in N/m.
+	skinCompression = 0.257
+	// Critical volume parameter (lambda) in bar minutes, equivalent to 6500 fsw
+	// minutes.
+	critVolumeLambda = 199.58
+	// Time constant in minutes for the regeneration of crushed nuclei.
+	regenerationTime = 20160.0
+	// Critical radii in metres of the Nitrogen and Helium nuclei at the lowest
+	// conservatism level.
+	critRadiusN2 = 0.55e-6
+	critRadiusHe = 0.45e-6
+	// Number of Pascals in one bar.
+	pascalsPerBar = 100000.0
+	// Maximum number of critical volume iterations when calculating a schedule.
+	maxIterations = 20
+	// Depth in metres between decompression stops.
+	stopInterval = 3.0
+	// Tolerance used when comparing stop depths.
+	epsilon = 1e-9
+	// Longest NDL that NDL() will search for when it is given a ceiling of
+	// zero.
+	DefaultMaxNDL time.Duration = 24 * time.Hour
+	// Highest conservatism level that can be set with SetConservatism().
+	MaxConservatism = 4
+	// Default ascent rate in m/min that NDL() assumes for the ascent to the
+	// surface, see SetAscentRate().
+	NDLAscentRate = 9.0
+)
+
+// Factors by which the critical radii are increased for each conservatism
+// level, equivalent to the +0 to +4 settings of other VPM-B planners.
+var conservatismFactors = [MaxConservatism + 1]float64{1.0, 1.05, 1.12, 1.22, 1.35}
+
+// Compartment half-times in minutes for Nitrogen and Helium as used by Baker.
+var (
+	n2HalfTimes = [compartCount]float64{
+		5.0, 8.0, 12.5, 18.5, 27.0, 38.3, 54.3, 77.0,
+		109.0, 146.0, 187.0, 239.0, 305.0, 390.0, 498.0, 635.0,
+	}
+	heHalfTimes = [compartCount]float64{
+		1.88, 3.02, 4.72, 6.99, 10.21, 14.48, 20.53, 29.11,
+		41.20, 55.19, 70.69, 90.34, 115.29, 147.42, 188.24, 240.03,
+	}
+)
+
+// Represents the pressure of each inert gas in a tissue compartment and the
+// maximum crushing pressure that its nuclei have been subjected to, all in bar.
+type compartModel struct {
+	pHe      float64
+	pN2      float64
+	maxCrush float64
+}
+
+// Represents the allowable supersaturation gradients in bar for Nitrogen and
+// Helium in a tissue compartment.
+type gradient struct {
+	n2 float64
+	he float64
+}
+
+// Check that Model implements the deco.Model interface.
+var _ deco.Model = (*Model)(nil)
+
+// Model is an implementation of the Varying Permeability Model with Boyle's law
+// compensation (VPM-B). The size of the gas nuclei in each tissue compartment
+// determines how much supersaturation is allowed during the ascent. Descending
+// crushes the nuclei, making them smaller and allowing more supersaturation.
+//
+// The impermeable region of the model, which only applies to extremely deep or
+// rapid descents, is not modelled and crushing pressures are calculated as if
+// the nuclei always remain permeable.
+type Model struct {
+	compartments *[compartCount]compartModel
+	currP        float64
+	currT        float64
+	gasMix       *gasmix.GasMix
+	conservatism int
+	// Depth in metres of the last decompression stop.
+	lastStop float64
+	// Ascent rate in m/min used by NDL() for the ascent to the surface.
+	ascentRate float64
+	// Gases available for use during decompression stops.
+	decoGases deco.Gases
+	// The environment used to convert between depth and pressure.
+	env helpers.Environment
+}
+
+// Constructor that creates, initialises and returns a new VPM-B model with the
+// lowest conservatism level. The diver's tissues are assumed to be fully
+// saturated with air at the surface.
+func New(gm *gasmix.GasMix) *Model {
+	return NewWithEnvironment(gm, helpers.DefaultEnvironment())
+}
+
+// NewWithEnvironment() is like New() but for a dive in the given environment.
+func NewWithEnvironment(gm *gasmix.GasMix, env helpers.Environment) *Model {
+	surfaceP := env.Pressure(0.0)
+
+	m := &Model{
+		compartments: &[compartCount]compartModel{},
+		currP:        surfaceP,
+		currT:        0.0,
+		gasMix:       gm,
+		lastStop:     stopInterval,
+		ascentRate:   NDLAscentRate,
+		env:          env,
+	}
+	m.saturate(surfaceP)
+
+	return m
+}
+
+// saturate() sets each of the model's compartments to be fully saturated with
+// air at the given ambient pressure in bar.
+func (m *Model) saturate(pamb float64) {
+	pN2 := deco.SaturatedN2(pamb, pH2O)
+	for i := range m.compartments {
+		m.compartments[i] = compartModel{pN2: pN2}
+	}
+}
+
+// Acclimatise() models a diver whose tissues are saturated with air at the
+// pressure fromP in bar, such as at sea-level, spending the given time in
+// minutes at the surface of the model's environment before the dive.
+func (m *Model) Acclimatise(fromP, time float64) {
+	m.saturate(fromP)
+	m.currP = m.env.Pressure(0.0)
+	deco.SurfaceInterval(m, time)
+
+	// The time spent acclimatising is not part of the dive itself.
+	m.currT = 0.0
+}
+
+// SetConservatism() sets the conservatism level of the model from 0 to
+// MaxConservatism. Higher levels increase the critical radii of the nuclei
+// which results in longer decompression.
+func (m *Model) SetConservatism(level int) error {
+	if level < 0 || level > MaxConservatism {
+		return fmt.Errorf("vpmb: Invalid conservatism value (%d), should be between 0 and %d inclusive", level, MaxConservatism)
+	}
+
+	m.conservatism = level
+	return nil
+}
+
+// Conservatism() returns the model's conservatism level.
+func (m *Model) Conservatism() int {
+	return m.conservatism
+}
+
+// SetLastStop() sets the depth of the last decompression stop in metres which
+// must be either 3 or 6 metres.
+func (m *Model) SetLastStop(depth float64) error {
+	if depth != stopInterval && depth != 2.0*stopInterval {
+		return fmt.Errorf("vpmb: Invalid last stop (%f), should be 3 or 6", depth)
+	}
+
+	m.lastStop = depth
+	return nil
+}
+
+// LastStop() returns the depth of the last decompression stop in metres.
+func (m *Model) LastStop() float64 {
+	return m.lastStop
+}
+
+// SetAscentRate() sets the ascent rate in m/min that NDL() assumes for the
+// ascent to the surface, which should match the rate given to DecoStops(). It
+// must be greater than zero.
+func (m *Model) SetAscentRate(rate float64) error {
+	if rate <= 0.0 {
+		return fmt.Errorf("vpmb: Invalid ascent rate (%f), should be greater than zero", rate)
+	}
+
+	m.ascentRate = rate
+	return nil
+}
+
+// AscentRate() returns the ascent rate in m/min that NDL() assumes.
+func (m *Model) AscentRate() float64 {
+	return m.ascentRate
+}
+
+// copyModel() returns a deep copy of the model.
+func (m *Model) copyModel() *Model {
+	compartCopy := *m.compartments
+
+	return &Model{
+		compartments: &compartCopy,
+		currP:        m.currP,
+		currT:        m.currT,
+		gasMix:       m.gasMix,
+		conservatism: m.conservatism,
+		lastStop:     m.lastStop,
+		ascentRate:   m.ascentRate,
+		decoGases:    append(deco.Gases(nil), m.decoGases...),
+		env:          m.env,
+	}
+}
+
+// Copy() returns a deep copy of the model which can be used to extrapolate
+// from the current state without modifying the original.
+func (m *Model) Copy() *Model {
+	return m.copyModel()
+}
+
+// Clone() is like Copy() but returns the copy as a deco.Model.
+func (m *Model) Clone() deco.Model {
+	return m.copyModel()
+}
+
+// GasMix() returns the gas mix that the model is currently using.
+func (m *Model) GasMix() *gasmix.GasMix {
+	return m.gasMix
+}
+
+// SwitchGas() changes the gas mix that the model uses for any subsequent
+// calculations.
+func (m *Model) SwitchGas(gm *gasmix.GasMix) {
+	m.gasMix = gm
+}
+
+// AddDecoGas() registers a gas mix that the model can switch to during
+// decompression stops. The gas is used at and above its MOD for the given
+// maximum PPO2 in bar.
+func (m *Model) AddDecoGas(gm *gasmix.GasMix, maxPPO2 float64) error {
+	return m.decoGases.Add(gm, maxPPO2, m.env)
+}
+
+// ClearDecoGases() removes all of the model's decompression gases.
+func (m *Model) ClearDecoGases() {
+	m.decoGases = nil
+}
+
+// TissuePressures() returns the total inert gas pressure in bar (Nitrogen plus
+// Helium) in each of the model's compartments.
+func (m *Model) TissuePressures() []float64 {
+	pressures := make([]float64, compartCount)
+	for i, c := range m.compartments {
+		pressures[i] = c.pHe + c.pN2
+	}
+	return pressures
+}
+
+// updateCrushing() records the crushing pressure that the nuclei in each
+// compartment are subjected to at the current ambient pressure if it is the
+// highest so far.
+func (m *Model) updateCrushing() {
+	for i, c := range m.compartments {
+		crush := m.currP - (c.pHe + c.pN2 + pOtherGases)
+		m.compartments[i].maxCrush = math.Max(c.maxCrush, crush)
+	}
+}
+
+// TransitionCalc() recalculates the model's compartment inert gas pressures
+// following a descent or ascent to the given depth at the given rate in m/min.
+// During a descent, the crushing pressures are also updated.
+func (m *Model) TransitionCalc(depth, rate float64) {
+	nextP := m.env.Pressure(depth)
+	pRate := m.env.PressureChangePerMin(rate)
+	if nextP < m.currP && rate >= 0.0 {
+		// We are ascending, so pressure change rate should be negative.
+		pRate *= -1.0
+	}
+	time := (nextP - m.currP) / pRate
+	descending := nextP > m.currP
+
+	if descending {
+		m.updateCrushing()
+	}
+
+	for i, c := range m.compartments {
+		m.compartments[i].pHe = deco.SchreinerEquation(m.currP, pH2O, time, pRate, m.gasMix.FHe, c.pHe, heHalfTimes[i])
+		m.compartments[i].pN2 = deco.SchreinerEquation(m.currP, pH2O, time, pRate, m.gasMix.FN2, c.pN2, n2HalfTimes[i])
+	}
+
+	m.currP = nextP
+	m.currT += math.Abs(time)
+
+	if descending {
+		m.updateCrushing()
+	}
+}
+
+// StopCalc() recalculates the model's compartment inert gas pressures when
+// staying at the current depth for the given time in minutes.
+func (m *Model) StopCalc(time float64) {
+	for i, c := range m.compartments {
+		m.compartments[i].pHe = deco.SchreinerEquation(m.currP, pH2O, time, 0.0, m.gasMix.FHe, c.pHe, heHalfTimes[i])
+		m.compartments[i].pN2 = deco.SchreinerEquation(m.currP, pH2O, time, 0.0, m.gasMix.FN2, c.pN2, n2HalfTimes[i])
+	}
+
+	m.currT += math.Abs(time)
+}
+
+// regeneratedRadius() returns the radius in metres of a nucleus with the given
+// critical radius after it has been subjected to the crushing pressure crush
+// in bar and then regenerated for the model's elapsed time, along with the
+// crushing pressure adjusted for the regeneration.
+func (m *Model) regeneratedRadius(critRadius, crush float64) (float64, float64) {
+	if crush <= 0.0 {
+		return critRadius, 0.0
+	}
+
+	crushPa := crush * pascalsPerBar
+	endRadius := 1.0 / (crushPa/(2.0*(skinCompression-surfaceTension)) + 1.0/critRadius)
+	regenRadius := critRadius + (endRadius-critRadius)*math.Exp(-m.currT/regenerationTime)
+	adjustRatio := (endRadius * (critRadius - regenRadius)) / (regenRadius * (critRadius - endRadius))
+
+	return regenRadius, crush * adjustRatio
+}
+
+// initialGradients() returns the initial allowable gradients for each
+// compartment based on the size of the nuclei at the model's conservatism
+// level after crushing and regeneration, along with the adjusted crushing
+// pressures.
+func (m *Model) initialGradients() ([compartCount]gradient, [compartCount]gradient) {
+	var grads, crushes [compartCount]gradient
+	factor := conservatismFactors[m.conservatism]
+
+	// allowable() returns the initial allowable gradient in bar for a nucleus
+	// with the given radius in metres.
+	allowable := func(radius float64) float64 {
+		g := 2.0 * surfaceTension * (skinCompression - surfaceTension) / (radius * skinCompression)
+		return g / pascalsPerBar
+	}
+
+	for i, c := range m.compartments {
+		rN2, crushN2 := m.regeneratedRadius(critRadiusN2*factor, c.maxCrush)
+		rHe, crushHe := m.regeneratedRadius(critRadiusHe*factor, c.maxCrush)
+		grads[i] = gradient{n2: allowable(rN2), he: allowable(rHe)}
+		crushes[i] = gradient{n2: crushN2, he: crushHe}
+	}
+
+	return grads, crushes
+}
+
+// ceilingWith() calculates the ascent ceiling depth in metres using the given
+// allowable gradients. The gradient for each compartment is weighted by the
+// pressure of each inert gas in it.
+func (m *Model) ceilingWith(grads [compartCount]gradient) float64 {
+	ceil := -(math.MaxFloat64)
+
+	for i, c := range m.compartments {
+		pInert := c.pHe + c.pN2
+		g := grads[i].n2
+		if pInert > 0.0 {
+			g = (grads[i].he*c.pHe + grads[i].n2*c.pN2) / pInert
+		}
+
+		ceil = math.Max(ceil, pInert+pOtherGases-g)
+	}
+
+	return m.env.Depth(ceil)
+}
+
+// Ceiling() returns the ascent ceiling in metres based on the model's current
+// compartment loading and initial allowable gradients. This determines the
+// depth of the first decompression stop, however, once the gradients have been
+// relaxed by the critical volume hypothesis, the stop may not be required, see
+// DecoStops().
+func (m *Model) Ceiling() float64 {
+	grads, _ := m.initialGradients()
+	return m.ceilingWith(grads)
+}
+
+// NDL() returns the No Decompression Limit at the current depth to the nearest
+// second, rounded down. This is the longest time the diver can stay at the
+// current depth and still ascend directly to the surface at the model's ascent
+// rate without any decompression stops, see SetAscentRate() and DecoStops(). If
+// the diver already needs a stop, then the NDL is zero even if the obligation
+// would clear by staying at the current depth.
+//
+// Whilst staying at one depth, each compartment's pressure moves steadily
+// towards the inspired inert gas pressure, so once a stop is needed it remains
+// needed, see buhlmann.ZhlModel.NDL(), and the NDL is found by bisection. Each
+// step of the search only models the direct ascent to the surface rather than
+// calculating a full schedule. The search is limited to maxNDL, or
+// DefaultMaxNDL if maxNDL is zero or negative, beyond which the result is reported as having no limit.
+func (m *Model) NDL(maxNDL time.Duration) deco.NDLResult {
+	if maxNDL <= 0 {
+		maxNDL = DefaultMaxNDL
+	}
+
+	// withinNDL() indicates if the diver can stay at the current depth for the
+	// given number of seconds without requiring any decompression stops.
+	withinNDL := func(secs int64) bool {
+		ndlModel := m.copyModel()
+		ndlModel.StopCalc(float64(secs) / 60.0)
+		return !ndlModel.needsStop(m.ascentRate)
+	}
+
+	lo, hi := int64(0), int64(maxNDL/time.Second)
+	if !withinNDL(lo) {
+		return deco.NDLResult{}
+	}
+
+	if withinNDL(hi) {
+		return deco.NDLResult{Duration: maxNDL, NoLimit: true}
+	}
+
+	for hi-lo > 1 {
+		mid := (lo + hi) / 2
+		if withinNDL(mid) {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+
+	return deco.NDLResult{Duration: time.Duration(lo) * time.Second}
+}
+
+// boyleRadius() returns the radius in metres that a bubble with the given
+// radius in metres at the pressure firstP in bar will expand to at the lower
+// pressure nextP in bar according to Boyle's law, taking the surface tension of
+// the bubble into account.
+func boyleRadius(radius, firstP, nextP float64) float64 {
+	p1, p2 := firstP*pascalsPerBar, nextP*pascalsPerBar
+	c := (p1 + 2.0*surfaceTension/radius) * radius * radius * radius
+
+	// f() is zero at the new radius, it is negative at the initial radius
+	// and positive at the radius given by Boyle's law without surface tension.
+	f := func(r float64) float64 {
+		return (p2*r+2.0*surfaceTension)*r*r - c
+	}
+
+	// The bisection stops once the bounds are within a billionth of the
+	// radius of each other.
+	lo, hi := radius, radius*math.Cbrt(p1/p2)
+	for i := 0; i < 100 && hi-lo > radius*1e-9; i++ {
+		mid := (lo + hi) / 2.0
+		if f(mid) < 0.0 {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+
+	return (lo + hi) / 2.0
+}
+
+// boyleGradients() returns the allowable gradients compensated for the
+// expansion of the bubbles during the ascent from the first stop at the
+// pressure firstP to the pressure nextP, both in bar.
+func boyleGradients(grads [compartCount]gradient, firstP, nextP float64) [compartCount]gradient {
+	if nextP >= firstP {
+		return grads
+	}
+
+	// compensate() returns the gradient in bar once a bubble that is in
+	// equilibrium with the given gradient at the first stop reaches nextP.
+	compensate := func(g float64) float64 {
+		r := 2.0 * surfaceTension / (g * pascalsPerBar)
+		return 2.0 * surfaceTension / boyleRadius(r, firstP, nextP) / pascalsPerBar
+	}
+
+	var compensated [compartCount]gradient
+	for i, g := range grads {
+		compensated[i] = gradient{n2: compensate(g.n2), he: compensate(g.he)}
+	}
+
+	return compensated
+}
+
+// surfacePhaseTime() returns the equivalent time in minutes that the
+// compartment at index i will remain supersaturated once at the surface,
+// based on its current inert gas pressures.
+func (m *Model) surfacePhaseTime(i int) float64 {
+	c := m.compartments[i]
+	inspN2 := deco.SaturatedN2(m.env.Pressure(0.0), pH2O)
+	kHe := math.Log(2.0) / heHalfTimes[i]
+	kN2 := math.Log(2.0) / n2HalfTimes[i]
+
+	if c.pN2 > inspN2 {
+		return (c.pHe/kHe + (c.pN2-inspN2)/kN2) / (c.pHe + c.pN2 - inspN2)
+	}
+
+	// If the total inert gas pressure equals the inspired Nitrogen pressure,
+	// then the compartment is not supersaturated at all.
+	if c.pHe > 0.0 && c.pHe+c.pN2 > inspN2 {
+		decayTime := 1.0 / (kN2 - kHe) * math.Log((inspN2-c.pN2)/c.pHe)
+		integral := c.pHe/kHe*(1.0-math.Exp(-kHe*decayTime)) +
+			(c.pN2-inspN2)/kN2*(1.0-math.Exp(-kN2*decayTime))
+		return integral / (c.pHe + c.pN2 - inspN2)
+	}
+
+	return 0.0
+}
+
+// criticalVolumeGradients() returns the allowable gradients relaxed by the
+// critical volume hypothesis for a decompression that took decoTime minutes,
+// where surfaced is the state of the model on surfacing.
+func criticalVolumeGradients(initial, crushes [compartCount]gradient, decoTime float64, surfaced *Model) [compartCount]gradient {
+	var grads [compartCount]gradient
+
+	// relax() returns the relaxed gradient for a gas in bar.
+	relax := func(g0, crush, phaseTime float64) float64 {
+		if phaseTime <= 0.0 {
+			return g0
+		}
+
+		ratio := surfaceTension / skinCompression
+		b := g0 + critVolumeLambda*ratio/phaseTime
+		c := ratio * ratio * critVolumeLambda * crush / phaseTime
+		return (b + math.Sqrt(b*b-4.0*c)) / 2.0
+	}
+
+	for i := range grads {
+		phaseTime := decoTime + surfaced.surfacePhaseTime(i)
+		grads[i] = gradient{
+			n2: relax(initial[i].n2, crushes[i].n2, phaseTime),
+			he: relax(initial[i].he, crushes[i].he, phaseTime),
+		}
+	}
+
+	return grads
+}
+
+// ascendToStop() models the ascent at the given rate in m/min to the stop at
+// currStop metres, switching to the best decompression gas there, for an
+// ascent whose first stop is at the pressure firstP in bar. It returns the
+// depth of the next stop and the given allowable gradients compensated for the
+// growth of the bubbles by the time it is reached.
+func (m *Model) ascendToStop(aRate, currStop, firstP float64, grads [compartCount]gradient) (float64, [compartCount]gradient) {
+	m.TransitionCalc(currStop, aRate)
+	m.SwitchGas(m.decoGases.Best(currStop, m.gasMix))
+
+	nextStop := currStop - stopInterval
+	if nextStop < m.lastStop-epsilon {
+		nextStop = 0.0
+	}
+
+	return nextStop, boyleGradients(grads, firstP, m.env.Pressure(nextStop))
+}
+
+// firstStop() returns the depth in metres of the first decompression stop
+// based on the given allowable gradients. Zero or less means that the diver can
+// ascend directly to the surface.
+func (m *Model) firstStop(grads [compartCount]gradient) float64 {
+	firstStop := math.Ceil(m.ceilingWith(grads)/stopInterval) * stopInterval
+	if firstStop > 0.0 && firstStop < m.lastStop {
+		return m.lastStop
+	}
+	return firstStop
+}
+
+// needsStop() indicates if the ascent to the surface at the given rate in m/min
+// requires any decompression stops, see DecoStops(). If no stops are needed,
+// then the decompression time is just the time taken by the ascent itself,
+// which relaxes the allowable gradients the most under the critical volume
+// hypothesis. So stops are only needed if they are still needed with these
+// gradients, which avoids calculating any stop lengths.
+func (m *Model) needsStop(aRate float64) bool {
+	initial, crushes := m.initialGradients()
+	firstStop := m.firstStop(initial)
+	if firstStop <= 0.0 {
+		return false
+	}
+
+	firstP := m.env.Pressure(firstStop)
+
+	// ascend() models the ascent to the surface without any stops and
+	// indicates if a stop was needed on the way with the given gradients.
+	// Once a stop is needed, the rest of the ascent is only modelled if
+	// toSurface is true.
+	ascend := func(model *Model, grads [compartCount]gradient, toSurface bool) bool {
+		needed := false
+		for currStop := firstStop; currStop >= m.lastStop-epsilon; currStop -= stopInterval {
+			if needed {
+				model.TransitionCalc(currStop, aRate)
+				model.SwitchGas(model.decoGases.Best(currStop, model.gasMix))
+				continue
+			}
+
+			nextStop, stopGrads := model.ascendToStop(aRate, currStop, firstP, grads)
+			if model.ceilingWith(stopGrads) >= nextStop {
+				if !toSurface {
+					return true
+				}
+				needed = true
+			}
+		}
+		model.TransitionCalc(0.0, aRate)
+		return needed
+	}
+
+	surfaced := m.copyModel()
+	if !ascend(surfaced, initial, true) {
+		return false
+	}
+
+	grads := criticalVolumeGradients(initial, crushes, surfaced.currT-m.currT, surfaced)
+	return ascend(m.copyModel(), grads, false)
+}
+
+// schedule() models the ascent to the surface from the first stop depth in
+// metres with the given allowable gradients and returns the decompression
+// stops. The model is left at the surface. If a stop does not clear within
+// deco.MaxStopTime, then the stops so far are returned, with that one capped,
+// along with an error and the model is left at that stop.
+func (m *Model) schedule(aRate, firstStop float64, grads [compartCount]gradient) ([]deco.Stop, error) {
+	var stops []deco.Stop
+	firstP := m.env.Pressure(firstStop)
+
+	for currStop := firstStop; currStop >= m.lastStop-epsilon; currStop -= stopInterval {
+		nextStop, stopGrads := m.ascendToStop(aRate, currStop, firstP, grads)
+		ac := m.ceilingWith(stopGrads)
+
+		// The stop can be skipped if the diver has off-gassed sufficiently
+		// during the ascent to it.
+		if ac < nextStop {
+			continue
+		}
+
+		stopLength := 0
+		for ac >= nextStop && float64(stopLength) < deco.MaxStopTime {
+			m.StopCalc(1.0)
+			ac = m.ceilingWith(stopGrads)
+			stopLength += 1
+		}
+
+		stops = append(stops, deco.Stop{
+			Depth:    currStop,
+			Duration: float64(stopLength),
+			GasMix:   m.gasMix,
+			Runtime:  m.currT,
+		})
+
+		if ac >= nextStop {
+			return stops, fmt.Errorf("vpmb: Decompression stop at %vm does not clear within %v minutes", currStop, deco.MaxStopTime)
+		}
+	}
+
+	m.TransitionCalc(0.0, aRate)
+	return stops, nil
+}
+
+// DecoStops() calculates the depth, length and gas mix of each decompression
+// stop for the model if the dive stopped wherever the model is currently up
+// to. Stops are every three metres up to and including the last stop, see
+// SetLastStop(), and their lengths are in whole minutes. The depth of the first stop
+// is found with the initial allowable gradients. The schedule is then
+// calculated repeatedly, relaxing the gradients according to the critical
+// volume hypothesis for the total decompression time of the previous schedule,
+// until the decompression time converges to within a minute. On arrival at each
+// stop, the model switches to the best available decompression gas for that
+// depth, see AddDecoGas(). If no decompression stops are required, then an
+// empty slice is returned. If a stop cannot be completed within
+// deco.MaxStopTime, then the stops so far are returned, with that one capped,
+// along with an error.
+func (m *Model) DecoStops(aRate float64) ([]deco.Stop, error) {
+	if !m.needsStop(aRate) {
+		return nil, nil
+	}
+
+	initial, crushes := m.initialGradients()
+	firstStop := m.firstStop(initial)
+
+	var stops []deco.Stop
+	grads := initial
+	lastDecoTime := -1.0
+
+	for i := 0; i < maxIterations; i++ {
+		model := m.copyModel()
+		var err error
+		if stops, err = model.schedule(aRate, firstStop, grads); err != nil {
+			return stops, err
+		}
+		decoTime := model.currT - m.currT
+
+		if math.Abs(decoTime-lastDecoTime) < 1.0 {
+			break
+		}
+
+		lastDecoTime = decoTime
+		grads = criticalVolumeGradients(initial, crushes, decoTime, model)
+	}
+
+	return stops, nil
+}
+
+// DecompStopLengths() calculates the length in minutes of each decompression
+// stop for the model if the dive stopped wherever the model is currently up
+// to, see DecoStops() for details. A stop that cannot be completed is capped
+// at deco.MaxStopTime, use DecoStops() to check for this.
+func (m *Model) DecompStopLengths(aRate float64) []int {
+	stops, _ := m.DecoStops(aRate)
+	return deco.StopLengths(stops)
+}
@@ -0,0 +1,258 @@
+package vpmb
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/m5lapp/diveplanner/deco"
+	"github.com/m5lapp/diveplanner/gasmix"
+)
+
+func equalIntSlice(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := 0; i < len(a); i++ {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestSetConservatism(t *testing.T) {
+	m := New(gasmix.NewAirMix())
+
+	for _, level := range []int{-1, MaxConservatism + 1} {
+		if err := m.SetConservatism(level); err == nil {
+			t.Errorf("want error for level %d; got: nil", level)
+		}
+	}
+
+	if err := m.SetConservatism(2); err != nil || m.Conservatism() != 2 {
+		t.Errorf("want: 2, nil; got: %d, %v", m.Conservatism(), err)
+	}
+}
+
+func TestNDL(t *testing.T) {
+	air := gasmix.NewAirMix()
+
+	tests := []struct {
+		depth float64
+		want  time.Duration
+	}{
+		{18.0, 48*time.Minute + 8*time.Second},
+		{24.0, 20*time.Minute + 4*time.Second},
+		{30.0, 11*time.Minute + 49*time.Second},
+		{40.0, 5*time.Minute + 29*time.Second},
+	}
+
+	for _, tt := range tests {
+		m := New(air)
+		m.TransitionCalc(tt.depth, 18.0)
+		if ndl := m.NDL(0); ndl.Duration != tt.want || ndl.NoLimit {
+			t.Errorf("%.0fm want: %v; got: %v", tt.depth, tt.want, ndl)
+		}
+
+		// The NDL must agree with the decompression schedule either side of
+		// it.
+		within := m.Copy()
+		within.StopCalc(tt.want.Minutes())
+		beyond := m.Copy()
+		beyond.StopCalc((tt.want + time.Second).Minutes())
+		withinStops, _ := within.DecoStops(NDLAscentRate)
+		beyondStops, _ := beyond.DecoStops(NDLAscentRate)
+		if len(withinStops) != 0 || len(beyondStops) == 0 {
+			t.Errorf("%.0fm NDL of %v does not match the decompression stops", tt.depth, tt.want)
+		}
+	}
+
+	// Already in deco at a shallower depth than the ceiling would clear at.
+	m := New(air)
+	m.TransitionCalc(30.0, 18.0)
+	m.StopCalc(40.0)
+	m.TransitionCalc(6.0, 9.0)
+	if stops, _ := m.DecoStops(NDLAscentRate); len(stops) == 0 {
+		t.Fatal("want decompression stops after 40min @ 30m")
+	}
+	if ndl := m.NDL(0); ndl != (deco.NDLResult{}) {
+		t.Errorf("in deco at 6m want: 0s; got: %v", ndl)
+	}
+
+	m = New(air)
+	m.TransitionCalc(5.0, 18.0)
+	if ndl := m.NDL(time.Hour); !ndl.NoLimit {
+		t.Errorf("5m want no limit; got: %v", ndl)
+	}
+
+	// The NDL is found for the model's ascent rate and must agree with the
+	// decompression schedule at that rate.
+	m = New(air)
+	m.TransitionCalc(30.0, 18.0)
+	if err := m.SetAscentRate(3.0); err != nil {
+		t.Fatal(err)
+	}
+	ndl := m.NDL(0)
+	if ndl.Duration == 11*time.Minute+49*time.Second {
+		t.Errorf("30m NDL at 3m/min want a different NDL to 9m/min; got: %v", ndl)
+	}
+
+	within := m.Copy()
+	within.StopCalc(ndl.Duration.Minutes())
+	beyond := m.Copy()
+	beyond.StopCalc((ndl.Duration + time.Second).Minutes())
+	withinStops, _ := within.DecoStops(3.0)
+	beyondStops, _ := beyond.DecoStops(3.0)
+	if len(withinStops) != 0 || len(beyondStops) == 0 {
+		t.Errorf("30m NDL of %v at 3m/min does not match the decompression stops", ndl.Duration)
+	}
+
+	if err := m.SetAscentRate(0.0); err == nil {
+		t.Error("want an error for an ascent rate of zero")
+	}
+}
+
+func TestDecompStopLengths(t *testing.T) {
+	air := gasmix.NewAirMix()
+	ean32, _ := gasmix.NewNitroxMix(0.32)
+	ean50, _ := gasmix.NewNitroxMix(0.50)
+	trimix2135, _ := gasmix.NewTrimixMix(0.21, 0.35)
+
+	tests := []struct {
+		name         string
+		gasMix       *gasmix.GasMix
+		decoGases    []*gasmix.GasMix
+		conservatism int
+		lastStop     float64
+		stops        [2]float64
+		wantFirst    float64
+		want         []int
+	}{
+		{
+			name:      "Air: 20min @ 30m",
+			gasMix:    air,
+			stops:     [2]float64{30.0, 20.0},
+			wantFirst: 9.0,
+			want:      []int{1, 3, 6},
+		},
+		{
+			name:      "Air: 20min @ 30m, last stop at 6m",
+			gasMix:    air,
+			lastStop:  6.0,
+			stops:     [2]float64{30.0, 20.0},
+			wantFirst: 9.0,
+			want:      []int{1, 13},
+		},
+		{
+			name:      "Air: 25min @ 45m",
+			gasMix:    air,
+			stops:     [2]float64{45.0, 25.0},
+			wantFirst: 24.0,
+			want:      []int{1, 1, 3, 3, 6, 7, 13, 21},
+		},
+		{
+			name:         "Air: 25min @ 45m, conservatism 2",
+			gasMix:       air,
+			conservatism: 2,
+			stops:        [2]float64{45.0, 25.0},
+			wantFirst:    24.0,
+			want:         []int{1, 2, 3, 4, 6, 9, 14, 25},
+		},
+		{
+			name:      "EAN32: 60min @ 30m",
+			gasMix:    ean32,
+			stops:     [2]float64{30.0, 60.0},
+			wantFirst: 9.0,
+			want:      []int{2, 7, 15},
+		},
+		{
+			name:      "Trimix2135: 22min @ 45m, EAN50",
+			gasMix:    trimix2135,
+			decoGases: []*gasmix.GasMix{ean50},
+			stops:     [2]float64{45.0, 22.0},
+			wantFirst: 18.0,
+			want:      []int{1, 1, 2, 2, 5, 8},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(tt.gasMix)
+			if err := m.SetConservatism(tt.conservatism); err != nil {
+				t.Fatal(err)
+			}
+			if tt.lastStop > 0.0 {
+				if err := m.SetLastStop(tt.lastStop); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, gm := range tt.decoGases {
+				if err := m.AddDecoGas(gm, 1.6); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			m.TransitionCalc(tt.stops[0], 20.0)
+			m.StopCalc(tt.stops[1])
+			modelBkup := m.copyModel()
+
+			stops, err := m.DecoStops(9.0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stops) == 0 || stops[0].Depth != tt.wantFirst {
+				t.Errorf("first stop want: %f; got: %v", tt.wantFirst, stops)
+			}
+
+			if dsl := m.DecompStopLengths(9.0); !equalIntSlice(dsl, tt.want) {
+				t.Errorf("want: %v; got: %v", tt.want, dsl)
+			}
+
+			// Check that the main model has not been modified.
+			if *m.compartments != *modelBkup.compartments || m.currT != modelBkup.currT || m.gasMix != tt.gasMix {
+				t.Error("model has been modified")
+			}
+		})
+	}
+
+	t.Run("No decompression", func(t *testing.T) {
+		m := New(air)
+		m.TransitionCalc(18.0, 18.0)
+		m.StopCalc(30.0)
+		if stops, err := m.DecoStops(9.0); len(stops) != 0 || err != nil {
+			t.Errorf("want no stops; got: %v, %v", stops, err)
+		}
+	})
+}
+
+func TestBoyleRadius(t *testing.T) {
+	r1 := 0.5e-6
+	r2 := boyleRadius(r1, 2.0, 1.0)
+
+	// The bubble should expand, but by less than Boyle's law alone would
+	// allow due to its surface tension.
+	if max := r1 * math.Cbrt(2.0); r2 <= r1 || r2 >= max {
+		t.Errorf("want radius between %g and %g; got: %g", r1, max, r2)
+	}
+}
+
+func TestSurfacePhaseTime(t *testing.T) {
+	m := New(gasmix.NewAirMix())
+	inspN2 := deco.SaturatedN2(m.env.Pressure(0.0), pH2O)
+
+	// Total inert gas pressure equal to the inspired Nitrogen pressure, which
+	// is not supersaturated.
+	m.compartments[0].pN2 = inspN2 - 0.1
+	m.compartments[0].pHe = 0.1
+	if got := m.surfacePhaseTime(0); got != 0.0 {
+		t.Errorf("want: 0; got: %v", got)
+	}
+
+	m.compartments[0].pHe = 0.2
+	if got := m.surfacePhaseTime(0); !(got > 0.0) {
+		t.Errorf("want a positive phase time; got: %v", got)
+	}
+}